@@ -0,0 +1,43 @@
+package achievements
+
+import "math"
+
+// Опыт для перехода с уровня n на n+1 растёт линейно: 100, 200, 300...
+// Уровень 2 — 100 XP, 3 — 300, 4 — 600, 5 — 1000.
+const levelStepXP = 100
+
+type LevelInfo struct {
+	Level       int   `json:"level"`
+	XP          int64 `json:"xp"`
+	LevelXP     int64 `json:"level_xp"`      // сколько опыта нужно для текущего уровня
+	NextLevelXP int64 `json:"next_level_xp"` // сколько опыта нужно для следующего уровня
+}
+
+// levelThreshold — опыт, с которого начинается уровень
+func levelThreshold(level int) int64 {
+	n := int64(level)
+	return levelStepXP * n * (n - 1) / 2
+}
+
+// LevelFor считает уровень по накопленному опыту
+func LevelFor(xp int64) LevelInfo {
+	if xp < 0 {
+		xp = 0
+	}
+
+	level := int((1 + math.Sqrt(1+8*float64(xp)/levelStepXP)) / 2)
+	// поправка на погрешность float
+	for levelThreshold(level+1) <= xp {
+		level++
+	}
+	for level > 1 && levelThreshold(level) > xp {
+		level--
+	}
+
+	return LevelInfo{
+		Level:       level,
+		XP:          xp,
+		LevelXP:     levelThreshold(level),
+		NextLevelXP: levelThreshold(level + 1),
+	}
+}
@@ -0,0 +1,45 @@
+package achievements
+
+import "testing"
+
+func TestLevelFor(t *testing.T) {
+	tests := []struct {
+		xp        int64
+		wantLevel int
+		wantXP    int64
+		wantFrom  int64
+		wantNext  int64
+	}{
+		{xp: -50, wantLevel: 1, wantXP: 0, wantFrom: 0, wantNext: 100},
+		{xp: 0, wantLevel: 1, wantXP: 0, wantFrom: 0, wantNext: 100},
+		{xp: 99, wantLevel: 1, wantXP: 99, wantFrom: 0, wantNext: 100},
+		{xp: 100, wantLevel: 2, wantXP: 100, wantFrom: 100, wantNext: 300},
+		{xp: 299, wantLevel: 2, wantXP: 299, wantFrom: 100, wantNext: 300},
+		{xp: 300, wantLevel: 3, wantXP: 300, wantFrom: 300, wantNext: 600},
+		{xp: 600, wantLevel: 4, wantXP: 600, wantFrom: 600, wantNext: 1000},
+		{xp: 999, wantLevel: 4, wantXP: 999, wantFrom: 600, wantNext: 1000},
+		{xp: 1000, wantLevel: 5, wantXP: 1000, wantFrom: 1000, wantNext: 1500},
+		{xp: 4500, wantLevel: 10, wantXP: 4500, wantFrom: 4500, wantNext: 5500},
+		{xp: 4499, wantLevel: 9, wantXP: 4499, wantFrom: 3600, wantNext: 4500},
+	}
+
+	for _, tt := range tests {
+		got := LevelFor(tt.xp)
+		if got.Level != tt.wantLevel || got.XP != tt.wantXP || got.LevelXP != tt.wantFrom || got.NextLevelXP != tt.wantNext {
+			t.Errorf("LevelFor(%d) = %+v, want level %d (%d..%d)", tt.xp, got, tt.wantLevel, tt.wantFrom, tt.wantNext)
+		}
+	}
+}
+
+// Границы уровней: 100·n(n−1)/2 — первый XP уровня n, на единицу меньше — ещё n−1
+func TestLevelForBoundaries(t *testing.T) {
+	for n := 2; n <= 500; n++ {
+		threshold := int64(levelStepXP * n * (n - 1) / 2)
+		if got := LevelFor(threshold).Level; got != n {
+			t.Fatalf("LevelFor(%d) = level %d, want %d", threshold, got, n)
+		}
+		if got := LevelFor(threshold - 1).Level; got != n-1 {
+			t.Fatalf("LevelFor(%d) = level %d, want %d", threshold-1, got, n-1)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package achievements
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go_stories_api/models"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Rule — разобранное условие ачивки (Achievement.Condition).
+//
+// Лист:      {"metric": "likes_received", "threshold": 100, "window": "30d"}
+// Составное: {"all": [<rule>, ...]} — И, {"any": [<rule>, ...]} — ИЛИ
+//
+// Старый формат {"type": "story_count", "value": 5} тоже понимается.
+type Rule struct {
+	Metric    string  `json:"metric,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Window    string  `json:"window,omitempty"` // 24h, 7d, 4w; пусто — за всё время
+	All       []Rule  `json:"all,omitempty"`
+	Any       []Rule  `json:"any,omitempty"`
+
+	window time.Duration
+}
+
+// Метрики, по которым можно выдавать ачивки
+const (
+	MetricStories         = "stories"          // опубликованные истории
+	MetricRepliesReceived = "replies_received" // ответы других на истории пользователя
+	MetricLikesReceived   = "likes_received"   // лайки других на истории пользователя
+	MetricFollowers       = "followers"        // подписчики (с окном — новые за период)
+	MetricViews           = "views"            // просмотры историй (с окном — историй, опубликованных за период)
+	MetricStreak          = "streak"           // текущая серия дней, окно не поддерживается
+	MetricComments        = "comments"         // написанные комментарии
+	MetricBranchDepth     = "branch_depth"     // глубина самого глубокого ответа пользователя в ветке
+	MetricHashtagsUsed    = "hashtags_used"    // разные хештеги в историях пользователя
+)
+
+// Ограничения, чтобы условие из API не превратилось в тяжёлый запрос
+const (
+	maxRuleDepth    = 4
+	maxRuleChildren = 10
+	maxWindow       = 365 * 24 * time.Hour
+)
+
+type metricFunc func(db *gorm.DB, userID uint, since *time.Time) (float64, error)
+
+var metrics = map[string]metricFunc{
+	MetricStories:         countStories,
+	MetricRepliesReceived: countRepliesReceived,
+	MetricLikesReceived:   countLikesReceived,
+	MetricFollowers:       countFollowers,
+	MetricViews:           sumViews,
+	MetricStreak:          streakLength,
+	MetricComments:        countComments,
+	MetricBranchDepth:     maxBranchDepth,
+	MetricHashtagsUsed:    countHashtagsUsed,
+}
+
+// Metrics — список поддерживаемых метрик
+func Metrics() []string {
+	return []string{
+		MetricStories, MetricRepliesReceived, MetricLikesReceived, MetricFollowers, MetricViews,
+		MetricStreak, MetricComments, MetricBranchDepth, MetricHashtagsUsed,
+	}
+}
+
+// Parse разбирает и проверяет условие. Пустое условие (ачивка выдаётся вручную) даёт nil.
+func Parse(raw []byte) (*Rule, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == "{}" {
+		return nil, nil
+	}
+
+	var legacy struct {
+		Type  string  `json:"type"`
+		Value float64 `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &legacy); err == nil && legacy.Type == "story_count" {
+		raw, _ = json.Marshal(Rule{Metric: MetricStories, Threshold: legacy.Value})
+	}
+
+	var rule Rule
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		return nil, fmt.Errorf("invalid condition: %v", err)
+	}
+	if err := rule.validate(1); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *Rule) validate(depth int) error {
+	if depth > maxRuleDepth {
+		return fmt.Errorf("condition is nested deeper than %d levels", maxRuleDepth)
+	}
+
+	kinds := 0
+	if r.Metric != "" {
+		kinds++
+	}
+	if r.All != nil {
+		kinds++
+	}
+	if r.Any != nil {
+		kinds++
+	}
+	if kinds != 1 {
+		return fmt.Errorf("condition must have exactly one of metric, all, any")
+	}
+
+	children := r.All
+	if r.Any != nil {
+		children = r.Any
+	}
+	if r.Metric == "" {
+		if r.Threshold != 0 || r.Window != "" {
+			return fmt.Errorf("threshold and window are only allowed with metric")
+		}
+		if len(children) == 0 || len(children) > maxRuleChildren {
+			return fmt.Errorf("all/any must contain 1 to %d conditions", maxRuleChildren)
+		}
+		for i := range children {
+			if err := children[i].validate(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if _, ok := metrics[r.Metric]; !ok {
+		return fmt.Errorf("unknown metric %q (supported: %s)", r.Metric, strings.Join(Metrics(), ", "))
+	}
+	if r.Threshold <= 0 || math.IsInf(r.Threshold, 0) {
+		return fmt.Errorf("threshold for %q must be positive", r.Metric)
+	}
+	if r.Window != "" {
+		if r.Metric == MetricStreak {
+			return fmt.Errorf("metric %q does not support window", r.Metric)
+		}
+		window, err := ParseWindow(r.Window)
+		if err != nil {
+			return err
+		}
+		r.window = window
+	}
+	return nil
+}
+
+// ParseWindow разбирает окно вида 12h, 7d, 4w
+func ParseWindow(s string) (time.Duration, error) {
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+
+	var unit time.Duration
+	switch s[len(s)-1] {
+	case 'h':
+		unit = time.Hour
+	case 'd':
+		unit = 24 * time.Hour
+	case 'w':
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid window %q: use h, d or w", s)
+	}
+
+	window := time.Duration(n) * unit
+	if window > maxWindow {
+		return 0, fmt.Errorf("window %q is longer than a year", s)
+	}
+	return window, nil
+}
+
+// Evaluate возвращает прогресс пользователя по условию: 0..1, 1 — выполнено.
+// Для all — среднее по частям (1 только когда выполнены все), для any — лучшая из частей.
+func (r *Rule) Evaluate(db *gorm.DB, userID uint) (float64, error) {
+	return r.evaluate(db, userID, time.Now())
+}
+
+func (r *Rule) evaluate(db *gorm.DB, userID uint, now time.Time) (float64, error) {
+	switch {
+	case r.All != nil:
+		var sum float64
+		for i := range r.All {
+			p, err := r.All[i].evaluate(db, userID, now)
+			if err != nil {
+				return 0, err
+			}
+			sum += p
+		}
+		return sum / float64(len(r.All)), nil
+
+	case r.Any != nil:
+		var best float64
+		for i := range r.Any {
+			p, err := r.Any[i].evaluate(db, userID, now)
+			if err != nil {
+				return 0, err
+			}
+			best = math.Max(best, p)
+			if best >= 1 {
+				break
+			}
+		}
+		return best, nil
+	}
+
+	value, err := r.measure(db, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	return math.Min(value/r.Threshold, 1), nil
+}
+
+func (r *Rule) measure(db *gorm.DB, userID uint, now time.Time) (float64, error) {
+	var since *time.Time
+	if r.window > 0 {
+		t := now.Add(-r.window)
+		since = &t
+	}
+	return metrics[r.Metric](db, userID, since)
+}
+
+// Metrics возвращает метрики, от которых зависит условие
+func (r *Rule) Metrics() []string {
+	if r.Metric != "" {
+		return []string{r.Metric}
+	}
+
+	seen := map[string]bool{}
+	var out []string
+	for _, children := range [][]Rule{r.All, r.Any} {
+		for i := range children {
+			for _, m := range children[i].Metrics() {
+				if !seen[m] {
+					seen[m] = true
+					out = append(out, m)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func count(query *gorm.DB) (float64, error) {
+	var n int64
+	err := query.Count(&n).Error
+	return float64(n), err
+}
+
+func countStories(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.Story{}).Where("user_id = ?", userID)
+	if since != nil {
+		query = query.Where("created_at >= ?", *since)
+	}
+	return count(query)
+}
+
+func countRepliesReceived(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.Story{}).
+		Joins("JOIN stories parent ON parent.id = stories.reply_to").
+		Where("parent.user_id = ? AND stories.user_id <> ?", userID, userID)
+	if since != nil {
+		query = query.Where("stories.created_at >= ?", *since)
+	}
+	return count(query)
+}
+
+func countLikesReceived(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.Like{}).
+		Joins("JOIN stories ON stories.id = likes.story_id").
+		Where("stories.user_id = ? AND likes.user_id <> ?", userID, userID)
+	if since != nil {
+		query = query.Where("likes.created_at >= ?", *since)
+	}
+	return count(query)
+}
+
+func countFollowers(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.Subscription{}).Where("following_id = ?", userID)
+	if since != nil {
+		query = query.Where("created_at >= ?", *since)
+	}
+	return count(query)
+}
+
+// Просмотры не хранятся по времени, поэтому окно отбирает истории по дате публикации
+func sumViews(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.Story{}).Where("user_id = ?", userID)
+	if since != nil {
+		query = query.Where("created_at >= ?", *since)
+	}
+
+	var total int64
+	err := query.Select("COALESCE(SUM(views), 0)").Scan(&total).Error
+	return float64(total), err
+}
+
+func streakLength(db *gorm.DB, userID uint, _ *time.Time) (float64, error) {
+	var profile models.Profile
+	err := db.Select("streak_count").Where("user_id = ?", userID).First(&profile).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	return float64(profile.StreakCount), err
+}
+
+func countComments(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.Comment{}).Where("user_id = ? AND is_deleted = ?", userID, false)
+	if since != nil {
+		query = query.Where("created_at >= ?", *since)
+	}
+	return count(query)
+}
+
+// maxBranchDepth — на каком уровне ветки находится самый глубокий ответ пользователя
+// (ответ на корневую историю — уровень 1)
+func maxBranchDepth(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	window := ""
+	args := []interface{}{userID}
+	if since != nil {
+		window = " AND s.created_at >= ?"
+		args = append(args, *since)
+	}
+
+	var depth int64
+	err := db.Raw(`
+		WITH RECURSIVE up AS (
+			SELECT s.reply_to AS parent, 1 AS depth
+			FROM stories s
+			WHERE s.user_id = ? AND s.reply_to IS NOT NULL`+window+`
+			UNION ALL
+			SELECT p.reply_to, up.depth + 1
+			FROM up JOIN stories p ON p.id = up.parent
+			WHERE p.reply_to IS NOT NULL AND up.depth < 1000
+		)
+		SELECT COALESCE(MAX(depth), 0) FROM up`, args...).Scan(&depth).Error
+	return float64(depth), err
+}
+
+func countHashtagsUsed(db *gorm.DB, userID uint, since *time.Time) (float64, error) {
+	query := db.Model(&models.StoryHashtag{}).
+		Joins("JOIN stories ON stories.id = story_hashtags.story_id").
+		Where("stories.user_id = ?", userID)
+	if since != nil {
+		query = query.Where("stories.created_at >= ?", *since)
+	}
+
+	var n int64
+	err := query.Distinct("story_hashtags.hashtag_id").Count(&n).Error
+	return float64(n), err
+}
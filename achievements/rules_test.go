@@ -0,0 +1,137 @@
+package achievements
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// nested оборачивает условие в depth уровней {"all": [...]}
+func nested(leaf string, depth int) string {
+	for i := 0; i < depth; i++ {
+		leaf = `{"all": [` + leaf + `]}`
+	}
+	return leaf
+}
+
+func TestParse(t *testing.T) {
+	leaf := `{"metric": "stories", "threshold": 1}`
+	tooMany := `{"any": [` + strings.TrimSuffix(strings.Repeat(leaf+",", 11), ",") + `]}`
+
+	tests := []struct {
+		name    string
+		raw     string
+		wantNil bool
+		wantErr string
+	}{
+		{name: "empty", raw: "", wantNil: true},
+		{name: "spaces", raw: "  \n", wantNil: true},
+		{name: "null", raw: "null", wantNil: true},
+		{name: "empty object", raw: "{}", wantNil: true},
+		{name: "leaf", raw: leaf},
+		{name: "leaf with window", raw: `{"metric": "likes_received", "threshold": 100, "window": "30d"}`},
+		{name: "legacy story_count", raw: `{"type": "story_count", "value": 5}`},
+		{name: "all and any", raw: `{"all": [{"metric": "followers", "threshold": 10}, {"any": [` + leaf + `, {"metric": "comments", "threshold": 3}]}]}`},
+		{name: "max depth", raw: nested(leaf, maxRuleDepth-1)},
+		{name: "too deep", raw: nested(leaf, maxRuleDepth), wantErr: "nested deeper"},
+		{name: "max children", raw: `{"any": [` + strings.TrimSuffix(strings.Repeat(leaf+",", 10), ",") + `]}`},
+		{name: "too many children", raw: tooMany, wantErr: "1 to 10 conditions"},
+		{name: "no children", raw: `{"all": []}`, wantErr: "1 to 10 conditions"},
+		{name: "unknown metric", raw: `{"metric": "karma", "threshold": 1}`, wantErr: "unknown metric"},
+		{name: "zero threshold", raw: `{"metric": "stories"}`, wantErr: "must be positive"},
+		{name: "negative threshold", raw: `{"metric": "stories", "threshold": -1}`, wantErr: "must be positive"},
+		{name: "window on streak", raw: `{"metric": "streak", "threshold": 7, "window": "7d"}`, wantErr: "does not support window"},
+		{name: "invalid window", raw: `{"metric": "stories", "threshold": 1, "window": "7m"}`, wantErr: "use h, d or w"},
+		{name: "metric and all", raw: `{"metric": "stories", "threshold": 1, "all": [` + leaf + `]}`, wantErr: "exactly one of"},
+		{name: "threshold on all", raw: `{"all": [` + leaf + `], "threshold": 2}`, wantErr: "only allowed with metric"},
+		{name: "unknown field", raw: `{"metric": "stories", "threshold": 1, "foo": 1}`, wantErr: "invalid condition"},
+		{name: "unknown legacy type", raw: `{"type": "likes", "value": 5}`, wantErr: "invalid condition"},
+		{name: "not json", raw: `stories > 5`, wantErr: "invalid condition"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse([]byte(tt.raw))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse(%s) error = %v, want %q", tt.raw, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%s) unexpected error: %v", tt.raw, err)
+			}
+			if (rule == nil) != tt.wantNil {
+				t.Fatalf("Parse(%s) = %+v, want nil: %v", tt.raw, rule, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestParseLegacy(t *testing.T) {
+	rule, err := Parse([]byte(`{"type": "story_count", "value": 5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Metric != MetricStories || rule.Threshold != 5 {
+		t.Fatalf("legacy condition parsed as %+v", rule)
+	}
+}
+
+func TestParseSetsWindow(t *testing.T) {
+	rule, err := Parse([]byte(`{"all": [{"metric": "followers", "threshold": 5, "window": "2w"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rule.All[0].window; got != 14*24*time.Hour {
+		t.Fatalf("window = %v, want 336h", got)
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "24h", want: 24 * time.Hour},
+		{in: "1h", want: time.Hour},
+		{in: "7d", want: 7 * 24 * time.Hour},
+		{in: "4w", want: 28 * 24 * time.Hour},
+		{in: "365d", want: 365 * 24 * time.Hour},
+		{in: "366d", wantErr: true},
+		{in: "53w", wantErr: true},
+		{in: "8761h", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "0d", wantErr: true},
+		{in: "-1d", wantErr: true},
+		{in: "7", wantErr: true},
+		{in: "7m", wantErr: true},
+		{in: "1.5d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseWindow(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseWindow(%q) = %v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseWindow(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRuleMetrics(t *testing.T) {
+	rule, err := Parse([]byte(`{"all": [{"metric": "stories", "threshold": 1}, {"any": [{"metric": "followers", "threshold": 1}, {"metric": "stories", "threshold": 2}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(rule.Metrics(), ",")
+	if got != "stories,followers" {
+		t.Fatalf("Metrics() = %s, want stories,followers", got)
+	}
+}
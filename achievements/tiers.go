@@ -0,0 +1,108 @@
+package achievements
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go_stories_api/models"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Порядок уровней многоуровневой ачивки
+var TierNames = []string{"bronze", "silver", "gold"}
+
+// ValidateTiers проверяет, что уровни идут по порядку bronze -> silver -> gold
+// и пороги растут
+func ValidateTiers(tiers []models.AchievementTier) error {
+	if len(tiers) == 0 {
+		return nil
+	}
+	if len(tiers) > len(TierNames) {
+		return fmt.Errorf("at most %d tiers are allowed", len(TierNames))
+	}
+
+	for i, t := range tiers {
+		if t.Name != TierNames[i] {
+			return fmt.Errorf("tier %d must be %q", i+1, TierNames[i])
+		}
+		if t.Threshold <= 0 || math.IsInf(t.Threshold, 0) {
+			return fmt.Errorf("threshold of tier %q must be positive", t.Name)
+		}
+		if i > 0 && t.Threshold <= tiers[i-1].Threshold {
+			return fmt.Errorf("threshold of tier %q must be greater than %q", t.Name, tiers[i-1].Name)
+		}
+		if t.XP < 0 {
+			return fmt.Errorf("xp of tier %q must not be negative", t.Name)
+		}
+	}
+	return nil
+}
+
+// ParseTiered разбирает условие многоуровневой ачивки: одна метрика (и окно) без составных
+// частей. Порог берётся из уровней, в условии его можно не указывать.
+func ParseTiered(raw []byte, tiers []models.AchievementTier) (*Rule, error) {
+	if err := ValidateTiers(tiers); err != nil {
+		return nil, err
+	}
+
+	var rule Rule
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rule); err != nil {
+		return nil, fmt.Errorf("invalid condition: %v", err)
+	}
+	if rule.Metric == "" {
+		return nil, fmt.Errorf("tiered achievement condition must be a single metric")
+	}
+
+	rule.Threshold = tiers[0].Threshold
+	if err := rule.validate(1); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ForAchievement разбирает условие ачивки с учётом её уровней
+func ForAchievement(ach models.Achievement) (*Rule, error) {
+	if len(ach.Tiers) > 0 {
+		return ParseTiered(ach.Condition, ach.Tiers)
+	}
+	return Parse(ach.Condition)
+}
+
+// Measure возвращает значение метрики условия (только для условия из одной метрики)
+func (r *Rule) Measure(db *gorm.DB, userID uint) (float64, error) {
+	if r.Metric == "" {
+		return 0, fmt.Errorf("measure requires a single metric condition")
+	}
+	return r.measure(db, userID, time.Now())
+}
+
+// TierProgress по значению метрики возвращает номер достигнутого уровня (-1 — ни одного)
+// и прогресс 0..1 до следующего уровня (1 — достигнут последний)
+func TierProgress(tiers []models.AchievementTier, value float64) (int, float64) {
+	reached := -1
+	for i, t := range tiers {
+		if value >= t.Threshold {
+			reached = i
+		}
+	}
+
+	if reached == len(tiers)-1 {
+		return reached, 1
+	}
+	return reached, math.Min(value/tiers[reached+1].Threshold, 1)
+}
+
+// TierIndex — номер уровня по имени (-1 — не найден или пусто)
+func TierIndex(tiers []models.AchievementTier, name string) int {
+	for i, t := range tiers {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,124 @@
+package achievements
+
+import (
+	"go_stories_api/models"
+	"strings"
+	"testing"
+)
+
+var testTiers = []models.AchievementTier{
+	{Name: "bronze", Threshold: 10, XP: 10},
+	{Name: "silver", Threshold: 50, XP: 25},
+	{Name: "gold", Threshold: 100, XP: 50},
+}
+
+func TestValidateTiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		tiers   []models.AchievementTier
+		wantErr string
+	}{
+		{name: "none", tiers: nil},
+		{name: "bronze only", tiers: testTiers[:1]},
+		{name: "all three", tiers: testTiers},
+		{name: "too many", tiers: append(append([]models.AchievementTier{}, testTiers...), models.AchievementTier{Name: "platinum", Threshold: 200}), wantErr: "at most 3"},
+		{name: "wrong order", tiers: []models.AchievementTier{{Name: "silver", Threshold: 10}}, wantErr: `must be "bronze"`},
+		{name: "zero threshold", tiers: []models.AchievementTier{{Name: "bronze", Threshold: 0}}, wantErr: "must be positive"},
+		{name: "not increasing", tiers: []models.AchievementTier{{Name: "bronze", Threshold: 10}, {Name: "silver", Threshold: 10}}, wantErr: "must be greater"},
+		{name: "negative xp", tiers: []models.AchievementTier{{Name: "bronze", Threshold: 10, XP: -1}}, wantErr: "must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTiers(tt.tiers)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTiered(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		tiers   []models.AchievementTier
+		wantErr string
+	}{
+		{name: "metric", raw: `{"metric": "stories"}`, tiers: testTiers},
+		{name: "metric with window", raw: `{"metric": "likes_received", "window": "30d"}`, tiers: testTiers},
+		{name: "threshold is ignored", raw: `{"metric": "stories", "threshold": 1000}`, tiers: testTiers},
+		{name: "composite", raw: `{"all": [{"metric": "stories", "threshold": 1}]}`, tiers: testTiers, wantErr: "single metric"},
+		{name: "unknown metric", raw: `{"metric": "karma"}`, tiers: testTiers, wantErr: "unknown metric"},
+		{name: "window on streak", raw: `{"metric": "streak", "window": "7d"}`, tiers: testTiers, wantErr: "does not support window"},
+		{name: "invalid tiers", raw: `{"metric": "stories"}`, tiers: []models.AchievementTier{{Name: "gold", Threshold: 1}}, wantErr: `must be "bronze"`},
+		{name: "not json", raw: `stories`, tiers: testTiers, wantErr: "invalid condition"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseTiered([]byte(tt.raw), tt.tiers)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rule.Threshold != tt.tiers[0].Threshold {
+				t.Fatalf("threshold = %v, want the first tier's %v", rule.Threshold, tt.tiers[0].Threshold)
+			}
+		})
+	}
+}
+
+func TestTierProgress(t *testing.T) {
+	tests := []struct {
+		value        float64
+		wantTier     int
+		wantProgress float64
+	}{
+		{value: 0, wantTier: -1, wantProgress: 0},
+		{value: 5, wantTier: -1, wantProgress: 0.5},
+		{value: 10, wantTier: 0, wantProgress: 0.2},
+		{value: 49, wantTier: 0, wantProgress: 0.98},
+		{value: 50, wantTier: 1, wantProgress: 0.5},
+		{value: 99, wantTier: 1, wantProgress: 0.99},
+		{value: 100, wantTier: 2, wantProgress: 1},
+		{value: 1000, wantTier: 2, wantProgress: 1},
+	}
+
+	for _, tt := range tests {
+		tier, progress := TierProgress(testTiers, tt.value)
+		if tier != tt.wantTier || progress != tt.wantProgress {
+			t.Errorf("TierProgress(%v) = %d, %v, want %d, %v", tt.value, tier, progress, tt.wantTier, tt.wantProgress)
+		}
+	}
+}
+
+func TestTierIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{name: "bronze", want: 0},
+		{name: "silver", want: 1},
+		{name: "gold", want: 2},
+		{name: "", want: -1},
+		{name: "platinum", want: -1},
+	}
+
+	for _, tt := range tests {
+		if got := TierIndex(testTiers, tt.name); got != tt.want {
+			t.Errorf("TierIndex(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
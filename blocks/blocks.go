@@ -0,0 +1,22 @@
+package blocks
+
+import (
+	"go_stories_api/models"
+
+	"gorm.io/gorm"
+)
+
+// Between — есть ли блокировка в любую сторону между a и b
+func Between(db *gorm.DB, a, b uint) bool {
+	var count int64
+	db.Model(&models.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", a, b, b, a).
+		Count(&count)
+	return count > 0
+}
+
+// UserIDs — подзапрос пользователей, заблокированных пользователем userID или заблокировавших его
+func UserIDs(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Raw(`SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+		UNION SELECT blocker_id FROM user_blocks WHERE blocked_id = ?`, userID, userID)
+}
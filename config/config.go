@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -17,6 +18,24 @@ type Config struct {
 	SMTPUser   string
 	SMTPPass   string
 	FromEmail  string
+	PublicURL  string // внешний адрес API, для ссылок в письмах
+
+	// Почта: smtp, file (письма в MAIL_DIR), memory или пусто (выключено)
+	MailDriver string
+	MailDir    string
+
+	// Брокер событий WebSocket между экземплярами: memory (один экземпляр) или postgres
+	WSBroker string
+
+	// Действия, которые продлевают серию: story, reply, comment (через запятую)
+	StreakActions []string
+
+	// Push-уведомления: onesignal, fcm, fake или пусто (выключено)
+	PushProvider       string
+	OneSignalAppID     string
+	OneSignalAPIKey    string
+	FCMProjectID       string
+	FCMCredentialsFile string
 }
 
 func LoadConfig() *Config {
@@ -32,6 +51,18 @@ func LoadConfig() *Config {
 		SMTPUser:   getEnv("SMTP_USER", ""),
 		SMTPPass:   getEnv("SMTP_PASS", ""), // ПАРОЛЬ С ПРОБЕЛАМИ РАБОТАЕТ
 		FromEmail:  getEnv("FROM_EMAIL", "noreply@storiesapp.com"),
+		PublicURL:  getEnv("PUBLIC_URL", "http://localhost:8080"),
+		MailDriver: getEnv("MAIL_DRIVER", "smtp"),
+		MailDir:    getEnv("MAIL_DIR", "tmp/mail"),
+		WSBroker:   getEnv("WS_BROKER", "memory"),
+
+		StreakActions: getEnvAsList("STREAK_ACTIONS", "story,reply"),
+
+		PushProvider:       getEnv("PUSH_PROVIDER", ""),
+		OneSignalAppID:     getEnv("ONESIGNAL_APP_ID", ""),
+		OneSignalAPIKey:    getEnv("ONESIGNAL_API_KEY", ""),
+		FCMProjectID:       getEnv("FCM_PROJECT_ID", ""),
+		FCMCredentialsFile: getEnv("FCM_CREDENTIALS_FILE", ""),
 	}
 }
 
@@ -49,4 +80,14 @@ func getEnvAsInt(key string, defaultValue int) int {
 		}
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+func getEnvAsList(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -20,7 +20,7 @@ func SeedAchievements(db *gorm.DB) {
 			Key:         "early_access",
 			Title:       "Первооткрыватель",
 			Description: "Войти под ранний доступ программы",
-			Icon:        "https://cdn.ravell.app/achievements/early_access.png",
+			Category:    "community",
 		},
 	}
 
@@ -33,6 +33,18 @@ func SeedAchievements(db *gorm.DB) {
 	}
 }
 
+// DSN — строка подключения к Postgres из переменных окружения
+func DSN() string {
+	return fmt.Sprintf(
+		"host=%s user=%s password=%s dbname=%s port=%s sslmode=require",
+		os.Getenv("DB_HOST"),
+		os.Getenv("DB_USER"),
+		os.Getenv("DB_PASSWORD"),
+		os.Getenv("DB_NAME"),
+		os.Getenv("DB_PORT"),
+	)
+}
+
 // InitDB инициализирует подключение к базе данных
 func InitDB() *gorm.DB {
 	gormLogger := logger.New(
@@ -45,16 +57,7 @@ func InitDB() *gorm.DB {
 			Colorful:                  true,
 		},
 	)
-	dsn := fmt.Sprintf(
-		"host=%s user=%s password=%s dbname=%s port=%s sslmode=require",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
-		os.Getenv("DB_PORT"),
-	)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+	db, err := gorm.Open(postgres.Open(DSN()), &gorm.Config{
 		Logger: gormLogger,
 	})
 	if err != nil {
@@ -80,21 +83,49 @@ func InitDB() *gorm.DB {
 
 // MigrateDB выполняет миграции
 func MigrateDB(db *gorm.DB) {
+	// Перед уникальным индексом (user_id, achievement_id) убираем дубли прогресса,
+	// оставляя полученную запись с наибольшим прогрессом
+	if db.Migrator().HasTable(&models.UserAchievement{}) {
+		db.Exec(`DELETE FROM user_achievements ua USING user_achievements keep
+			WHERE ua.user_id = keep.user_id AND ua.achievement_id = keep.achievement_id
+				AND (keep.unlocked, keep.progress, -keep.id) > (ua.unlocked, ua.progress, -ua.id)`)
+	}
+
 	err := db.AutoMigrate(
 		&models.User{},
 		&models.Profile{},
 		&models.Story{},
 		&models.Comment{},
+		&models.CommentLike{},
 		&models.Like{},
 		&models.Subscription{},
+		&models.UserBlock{},
 		&models.Hashtag{},
 		&models.StoryHashtag{},
+		&models.HashtagFollow{},
+		&models.HashtagAlias{},
+		&models.Mention{},
 		&models.NotInterested{},
 		&models.UserDevice{},
 		&models.PostView{},
 		&models.Feature{},
 		&models.Achievement{},
 		&models.UserAchievement{},
+		&models.TrendingHashtag{},
+		&models.TrendingStory{},
+		&models.Notification{},
+		&models.NotificationPreference{},
+		&models.NotificationSettings{},
+		&models.EmailOutbox{},
+		&models.UserPresence{},
+		&models.StoryReader{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.AchievementRecompute{},
+		&models.XPEvent{},
+		&models.LeaderboardEntry{},
+		&models.StreakActivity{},
+		&models.StreakMilestone{},
 	)
 	if err != nil {
 		log.Printf("Migration error: %v", err)
@@ -105,6 +136,22 @@ func MigrateDB(db *gorm.DB) {
 	// Принудительно добавляем колонки, если AutoMigrate буксует
 	db.Exec("ALTER TABLE stories ADD COLUMN IF NOT EXISTS views INTEGER DEFAULT 0")
 	db.Exec("ALTER TABLE post_views ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()")
+	// Одна непрочитанная группа уведомлений на пользователя: старые дубли считаем прочитанными
+	db.Exec(`UPDATE notifications n SET read_at = NOW() FROM notifications newer
+		WHERE n.user_id = newer.user_id AND n.group_key = newer.group_key AND n.group_key <> ''
+			AND n.read_at IS NULL AND newer.read_at IS NULL AND newer.id > n.id`)
+	db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_unread_group ON notifications (user_id, group_key) WHERE read_at IS NULL AND group_key <> ''")
+	// Для ачивок, полученных до появления unlocked_at, берём время последнего обновления
+	db.Exec("UPDATE user_achievements SET unlocked_at = updated_at WHERE unlocked = TRUE AND unlocked_at IS NULL")
+	// early_access раньше выдавался при открытии профиля, теперь — по событиям регистрации
+	// и раннего доступа. Тем, кто получил is_early до этого и остался без ачивки, выдаём её здесь.
+	db.Exec(`INSERT INTO user_achievements (user_id, achievement_id, progress, unlocked, unlocked_at, created_at, updated_at)
+		SELECT profiles.user_id, achievements.id, 1, TRUE, NOW(), NOW(), NOW()
+		FROM profiles JOIN achievements ON achievements.key = 'early_access' AND achievements.archived_at IS NULL
+		WHERE profiles.is_early = TRUE
+		ON CONFLICT (user_id, achievement_id) DO UPDATE
+		SET progress = 1, unlocked = TRUE, unlocked_at = NOW(), updated_at = NOW()
+		WHERE user_achievements.unlocked = FALSE AND user_achievements.revoked = FALSE`)
 
 	log.Println("✅ Database migration and seeding completed")
 }
@@ -0,0 +1,142 @@
+package events
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Доменные события. UserID — пользователь, чьи показатели изменились,
+// ActorID — кто совершил действие (если это другой пользователь).
+const (
+	StoryCreated       = "story.created"        // UserID — автор, TargetID — история
+	ReplyReceived      = "story.reply_received" // UserID — автор родительской истории, TargetID — ответ
+	StoryLiked         = "story.liked"          // UserID — автор истории, TargetID — история
+	UserFollowed       = "user.followed"        // UserID — на кого подписались
+	CommentCreated     = "comment.created"      // UserID — автор комментария, TargetID — комментарий
+	StreakUpdated      = "streak.updated"       // UserID — владелец серии
+	UserRegistered     = "user.registered"
+	EarlyAccessGranted = "user.early_access"
+)
+
+type Event struct {
+	Type     string
+	UserID   uint
+	ActorID  uint
+	TargetID uint
+	At       time.Time
+}
+
+// Handler обрабатывает событие асинхронно, вне запроса, который его породил
+type Handler func(Event)
+
+const queueSize = 256
+
+var bus = struct {
+	sync.RWMutex
+	handlers map[string][]Handler
+	queues   []chan Event
+}{handlers: map[string][]Handler{}}
+
+// Обработчики очередей; Wait ждёт их завершения после остановки
+var workers sync.WaitGroup
+
+// Subscribe регистрирует обработчик для перечисленных типов событий
+func Subscribe(h Handler, types ...string) {
+	bus.Lock()
+	defer bus.Unlock()
+	for _, t := range types {
+		bus.handlers[t] = append(bus.handlers[t], h)
+	}
+}
+
+// Start запускает обработчиков событий. События одного пользователя всегда попадают
+// в одну очередь и обрабатываются по порядку. После отмены ctx новые события в очереди
+// не ставятся, а уже поставленные дообрабатываются.
+func Start(ctx context.Context, n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	queues := make([]chan Event, n)
+	for i := range queues {
+		queues[i] = make(chan Event, queueSize)
+		workers.Add(1)
+		go func(queue chan Event) {
+			defer workers.Done()
+			for ev := range queue {
+				dispatch(ev)
+			}
+		}(queues[i])
+	}
+
+	bus.Lock()
+	bus.queues = queues
+	bus.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		// Publish отправляет в очередь под RLock, поэтому после Lock в них уже никто не пишет
+		bus.Lock()
+		bus.queues = nil
+		bus.Unlock()
+		for _, queue := range queues {
+			close(queue)
+		}
+	}()
+}
+
+// Wait ждёт, пока после остановки будут обработаны события из очередей, но не дольше ctx
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Publish ставит событие в очередь и не блокирует вызывающего
+func Publish(ev Event) {
+	if ev.At.IsZero() {
+		ev.At = time.Now()
+	}
+
+	bus.RLock()
+	defer bus.RUnlock()
+
+	if len(bus.queues) == 0 {
+		go dispatch(ev)
+		return
+	}
+
+	select {
+	case bus.queues[ev.UserID%uint(len(bus.queues))] <- ev:
+	default:
+		log.Printf("Events: queue is full, dropping %s for user %d", ev.Type, ev.UserID)
+	}
+}
+
+func dispatch(ev Event) {
+	bus.RLock()
+	handlers := bus.handlers[ev.Type]
+	bus.RUnlock()
+
+	for _, h := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Events: handler for %s panicked: %v", ev.Type, r)
+				}
+			}()
+			h(ev)
+		}()
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go_stories_api/achievements"
+	"go_stories_api/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	recomputeBatchSize = 200
+	// Пересчёт, который столько не обновлялся, считается брошенным и может быть подхвачен.
+	// Аренда продлевается после каждого пользователя.
+	recomputeLease = 2 * time.Minute
+)
+
+// StartAchievementRecompute ставит пересчёт одной ачивки (или всех, если achievementID == nil).
+// Если такой пересчёт уже идёт, был прерван или упал, возвращает его, чтобы продолжить с курсора.
+func StartAchievementRecompute(db *gorm.DB, achievementID *uint) (models.AchievementRecompute, error) {
+	var job models.AchievementRecompute
+
+	query := db.Where("status IN ?", []string{"pending", "running", "failed"})
+	if achievementID != nil {
+		query = query.Where("achievement_id = ?", *achievementID)
+	} else {
+		query = query.Where("achievement_id IS NULL")
+	}
+	if err := query.Order("id").First(&job).Error; err == nil {
+		return job, nil
+	}
+
+	job = models.AchievementRecompute{AchievementID: achievementID, Status: "pending"}
+	db.Model(&models.User{}).Count(&job.TotalUsers)
+	err := db.Create(&job).Error
+	return job, err
+}
+
+// RunAchievementRecompute выполняет пересчёт пачками по пользователям, сохраняя курсор после каждого.
+// Полученные ачивки не понижаются, отозванные не выдаются заново. Возвращается, если пересчёт уже ведёт другой процесс
+// или аренду перехватили (тогда этот запуск молча останавливается, не трогая курсор).
+func RunAchievementRecompute(ctx context.Context, db *gorm.DB, jobID uint) error {
+	now := time.Now()
+	token := newClaimToken()
+	claim := db.Model(&models.AchievementRecompute{}).
+		Where("id = ? AND (status IN ? OR (status = ? AND updated_at < ?))", jobID, []string{"pending", "failed"}, "running", now.Add(-recomputeLease)).
+		Updates(map[string]interface{}{
+			"status":     "running",
+			"last_error": "",
+			"claimed_by": token,
+			"started_at": gorm.Expr("COALESCE(started_at, ?)", now),
+		})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		return nil
+	}
+
+	var job models.AchievementRecompute
+	if err := db.First(&job, jobID).Error; err != nil {
+		return err
+	}
+
+	// owned обновляет пересчёт, только пока он за этим запуском; false — аренду перехватили
+	owned := func(updates map[string]interface{}) (bool, error) {
+		result := db.Model(&models.AchievementRecompute{}).
+			Where("id = ? AND claimed_by = ?", job.ID, token).
+			Updates(updates)
+		return result.RowsAffected > 0, result.Error
+	}
+	fail := func(err error) error {
+		owned(map[string]interface{}{"status": "failed", "last_error": err.Error()})
+		log.Printf("Achievements: recompute #%d failed: %v", job.ID, err)
+		return err
+	}
+
+	var all []models.Achievement
+	query := db.Where("condition IS NOT NULL AND archived_at IS NULL")
+	if job.AchievementID != nil {
+		query = query.Where("id = ?", *job.AchievementID)
+	}
+	if err := query.Find(&all).Error; err != nil {
+		return fail(err)
+	}
+
+	type compiled struct {
+		ach  models.Achievement
+		rule *achievements.Rule
+	}
+	var rules []compiled
+	for _, ach := range all {
+		rule, err := achievements.ForAchievement(ach)
+		if err != nil {
+			log.Printf("Achievements: skipping %q: %v", ach.Key, err)
+			continue
+		}
+		if rule != nil {
+			rules = append(rules, compiled{ach, rule})
+		}
+	}
+	if job.AchievementID != nil && len(rules) == 0 {
+		return fail(errors.New("achievement has no valid condition"))
+	}
+
+	for {
+		var userIDs []uint
+		if err := db.Model(&models.User{}).
+			Where("id > ?", job.LastUserID).
+			Order("id").
+			Limit(recomputeBatchSize).
+			Pluck("id", &userIDs).Error; err != nil {
+			return fail(err)
+		}
+		if len(userIDs) == 0 {
+			break
+		}
+
+		for _, userID := range userIDs {
+			if ctx.Err() != nil {
+				// Курсор сохранён; pending, а не running — чтобы повторный запуск подхватил
+				// пересчёт сразу, не дожидаясь истечения аренды
+				owned(map[string]interface{}{"status": "pending", "claimed_by": ""})
+				return ctx.Err()
+			}
+
+			for _, r := range rules {
+				got, err := evaluateAchievement(db, userID, r.ach, r.rule)
+				if err != nil {
+					return fail(fmt.Errorf("evaluate %q for user %d: %v", r.ach.Key, userID, err))
+				}
+				if got {
+					job.Unlocked++
+				}
+			}
+
+			// Курсор после каждого пользователя заодно продлевает аренду (updated_at)
+			job.LastUserID = userID
+			job.ProcessedUsers++
+			ok, err := owned(map[string]interface{}{
+				"last_user_id":    job.LastUserID,
+				"processed_users": job.ProcessedUsers,
+				"unlocked":        job.Unlocked,
+			})
+			if err != nil {
+				return fail(err)
+			}
+			if !ok {
+				log.Printf("Achievements: recompute #%d was taken over by another process", job.ID)
+				return nil
+			}
+		}
+	}
+
+	finished := time.Now()
+	owned(map[string]interface{}{"status": "done", "finished_at": finished, "claimed_by": ""})
+	log.Printf("Achievements: recompute #%d done: %d users, %d unlocked", job.ID, job.ProcessedUsers, job.Unlocked)
+	return nil
+}
+
+func newClaimToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ResumeAchievementRecomputes подхватывает пересчёты, прерванные остановкой сервера
+func ResumeAchievementRecomputes(ctx context.Context, db *gorm.DB) {
+	var ids []uint
+	db.Model(&models.AchievementRecompute{}).
+		Where("status = ? OR (status = ? AND updated_at < ?)", "pending", "running", time.Now().Add(-recomputeLease)).
+		Order("id").
+		Pluck("id", &ids)
+
+	if len(ids) == 0 {
+		return
+	}
+	go func() {
+		for _, id := range ids {
+			if err := RunAchievementRecompute(ctx, db, id); err != nil && ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+}
+
+func recomputeResponse(job models.AchievementRecompute) gin.H {
+	var percent float64
+	if job.TotalUsers > 0 {
+		percent = float64(job.ProcessedUsers) / float64(job.TotalUsers) * 100
+		if percent > 100 {
+			percent = 100
+		}
+	}
+	return gin.H{"recompute": job, "percent": percent}
+}
+
+// RecomputeAchievements запускает пересчёт прогресса по всем пользователям.
+// {"key": "..."} — одна ачивка, без key — все ачивки с условием.
+func RecomputeAchievements(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	var req struct {
+		Key string `json:"key"`
+	}
+	c.ShouldBindJSON(&req)
+
+	var achievementID *uint
+	if req.Key != "" {
+		var ach models.Achievement
+		if err := db.Where("key = ?", req.Key).First(&ach).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Achievement not found"})
+			return
+		}
+		if ach.ArchivedAt != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Achievement is archived"})
+			return
+		}
+		rule, err := achievements.ForAchievement(ach)
+		if err != nil || rule == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Achievement has no condition to recompute"})
+			return
+		}
+		achievementID = &ach.ID
+	}
+
+	job, err := StartAchievementRecompute(db, achievementID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start recompute"})
+		return
+	}
+
+	go RunAchievementRecompute(context.Background(), db, job.ID)
+
+	c.JSON(http.StatusAccepted, recomputeResponse(job))
+}
+
+// GetAchievementRecompute — состояние пересчёта
+func GetAchievementRecompute(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recompute ID"})
+		return
+	}
+
+	var job models.AchievementRecompute
+	if err := db.First(&job, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recompute not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, recomputeResponse(job))
+}
@@ -1,17 +1,29 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"go_stories_api/achievements"
+	"go_stories_api/events"
 	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/push"
+	"log"
+	"math"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-// Получение ачивок пользователя
+// Получение ачивок пользователя: все действующие ачивки по категориям и порядку с прогрессом.
+// Секретные показываются только полученными, архивные — только тем, у кого они есть.
 func GetUserAchievementsByID(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
 	idParam := c.Param("id")
@@ -23,120 +35,268 @@ func GetUserAchievementsByID(c *gin.Context) {
 	}
 
 	var userAchievements []models.UserAchievement
-	err = db.Preload("Achievement").Where("user_id = ?", userID).Find(&userAchievements).Error
-	if err != nil {
+	if err := db.Where("user_id = ?", userID).Find(&userAchievements).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+		return
+	}
+	owned := make(map[uint]models.UserAchievement, len(userAchievements))
+	for _, ua := range userAchievements {
+		owned[ua.AchievementID] = ua
+	}
+
+	query := db.Order("category, sort_order, id")
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+	var all []models.Achievement
+	if err := query.Find(&all).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
 		return
 	}
 
-	// Создаем ачивки на лету, если их нет
-	if len(userAchievements) == 0 {
-		var allAchievements []models.Achievement
-		db.Find(&allAchievements)
+	result := make([]models.UserAchievement, 0, len(all))
+	hidden := 0
+	for _, a := range all {
+		userAch, ok := owned[a.ID]
+		if !userAch.Unlocked && (a.Hidden || a.ArchivedAt != nil) {
+			if a.ArchivedAt == nil {
+				hidden++
+			}
+			continue
+		}
 
-		userAchievements = make([]models.UserAchievement, len(allAchievements))
-		for i, a := range allAchievements {
-			userAchievements[i] = models.UserAchievement{
+		// Прогресс ещё не сохранялся — считаем на лету
+		if !ok {
+			progress, _ := calculateProgress(db, uint(userID), a)
+			userAch = models.UserAchievement{
 				UserID:        uint(userID),
 				AchievementID: a.ID,
-				Progress:      0,
-				Unlocked:      false,
-				Achievement:   a,
+				Progress:      progress,
 			}
 		}
+		userAch.Achievement = a
+		result = append(result, userAch)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"achievements": userAchievements})
+	c.JSON(http.StatusOK, gin.H{"achievements": result, "hidden_count": hidden})
 }
 
-// Обновление прогресса всех ачивок для всех пользователей
+// Обновление прогресса всех ачивок с условием для всех пользователей
 func UpdateAllAchievements(db *gorm.DB) {
-	var achievements []models.Achievement
+	job, err := StartAchievementRecompute(db, nil)
+	if err != nil {
+		log.Printf("Achievements: failed to start recompute: %v", err)
+		return
+	}
+	RunAchievementRecompute(context.Background(), db, job.ID)
+}
+
+// Обновление прогресса одной ачивки конкретного пользователя.
+// Полученную, отозванную и архивные ачивки не трогает. Возвращает true, если ачивка открылась сейчас.
+func UpdateAchievementProgress(db *gorm.DB, userID uint, key string, progress float64) bool {
+	var ach models.Achievement
+	if err := db.Where("key = ? AND archived_at IS NULL", key).First(&ach).Error; err != nil {
+		return false
+	}
 
-	var users []models.User
+	unlocked := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userAch, err := lockUserAchievement(tx, userID, ach.ID)
+		if err != nil || userAch.Unlocked || userAch.Revoked {
+			return err
+		}
 
-	for _, ach := range achievements {
-		for _, user := range users {
-			progress := calculateProgress(db, user.ID, ach)
-			UpdateAchievementProgress(db, user.ID, ach.Key, progress)
+		userAch.Progress = progress
+		if progress >= 1 {
+			unlock(&userAch)
+			userAch.Progress = 1
+			unlocked = true
 		}
+		return tx.Save(&userAch).Error
+	})
+	if err != nil {
+		log.Printf("Achievements: failed to update %q for user %d: %v", key, userID, err)
+		return false
 	}
+
+	if unlocked {
+		goReward(db, userID, ach, "")
+	}
+	return unlocked
 }
 
-// Обновление прогресса одной ачивки конкретного пользователя
-func UpdateAchievementProgress(db *gorm.DB, userID uint, key string, progress float64) {
-	var ach models.Achievement
-	if err := db.Where("key = ?", key).First(&ach).Error; err != nil {
-		return
+// lockUserAchievement создаёт при необходимости запись прогресса и блокирует её до конца
+// транзакции: события пользователя и пересчёт пишут в неё параллельно
+func lockUserAchievement(tx *gorm.DB, userID, achievementID uint) (models.UserAchievement, error) {
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "achievement_id"}},
+		DoNothing: true,
+	}).Create(&models.UserAchievement{UserID: userID, AchievementID: achievementID}).Error; err != nil {
+		return models.UserAchievement{}, err
 	}
 
 	var userAch models.UserAchievement
-	err := db.Where("user_id = ? AND achievement_id = ?", userID, ach.ID).First(&userAch).Error
-	if err != nil {
-		if err == gorm.ErrRecordNotFound {
-			userAch = models.UserAchievement{
-				UserID:        userID,
-				AchievementID: ach.ID,
-				Progress:      progress,
-				Unlocked:      progress >= 1,
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND achievement_id = ?", userID, achievementID).
+		First(&userAch).Error
+	return userAch, err
+}
+
+// unlock отмечает ачивку полученной, сохраняя время первого получения
+func unlock(userAch *models.UserAchievement) {
+	userAch.Unlocked = true
+	if userAch.UnlockedAt == nil {
+		now := time.Now()
+		userAch.UnlockedAt = &now
+	}
+}
+
+// updateTieredProgress обновляет многоуровневую ачивку по значению метрики.
+// Уровень только растёт; за каждый новый уровень начисляется опыт и приходит уведомление.
+// Отозванную ачивку не трогает.
+// Возвращает true, если достигнут новый уровень.
+func updateTieredProgress(db *gorm.DB, userID uint, ach models.Achievement, value float64) bool {
+	current, reached := -1, -1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userAch, err := lockUserAchievement(tx, userID, ach.ID)
+		if err != nil || userAch.Revoked {
+			return err
+		}
+
+		current = achievements.TierIndex(ach.Tiers, userAch.Tier)
+		var progress float64
+		reached, progress = achievements.TierProgress(ach.Tiers, value)
+		if reached < current {
+			// Метрика просела (окно) — уровень не понижаем, прогресс считаем к следующему за текущим
+			reached = current
+			progress = 1
+			if current < len(ach.Tiers)-1 {
+				progress = math.Min(value/ach.Tiers[current+1].Threshold, 1)
 			}
-			db.Create(&userAch)
-			return
 		}
+
+		userAch.Progress = progress
+		if reached >= 0 {
+			unlock(&userAch)
+			userAch.Tier = ach.Tiers[reached].Name
+		}
+		return tx.Save(&userAch).Error
+	})
+	if err != nil {
+		log.Printf("Achievements: failed to update %q for user %d: %v", ach.Key, userID, err)
+		return false
 	}
 
-	userAch.Progress = progress
-	if progress >= 1 {
-		userAch.Unlocked = true
-		userAch.Progress = 1
+	for i := current + 1; i <= reached; i++ {
+		goReward(db, userID, ach, ach.Tiers[i].Name)
 	}
-	db.Save(&userAch)
+	return reached > current
 }
 
-// Создание ачивки через API
-func CreateAchievement(c *gin.Context) {
-	db := c.MustGet("db").(*gorm.DB)
-
-	var input struct {
-		Key         string          `json:"key" binding:"required"`
-		Title       string          `json:"title" binding:"required"`
-		Description string          `json:"description"`
-		Icon        string          `json:"icon"`
-		Condition   json.RawMessage `json:"condition"`
+// evaluateAchievement считает условие ачивки для пользователя и сохраняет прогресс.
+// Возвращает true, если ачивка (или её новый уровень) получена сейчас.
+func evaluateAchievement(db *gorm.DB, userID uint, ach models.Achievement, rule *achievements.Rule) (bool, error) {
+	if len(ach.Tiers) > 0 {
+		value, err := rule.Measure(db, userID)
+		if err != nil {
+			return false, err
+		}
+		return updateTieredProgress(db, userID, ach, value), nil
 	}
 
-	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	progress, err := rule.Evaluate(db, userID)
+	if err != nil {
+		return false, err
 	}
+	return UpdateAchievementProgress(db, userID, ach.Key, progress), nil
+}
 
-	var exist models.Achievement
-	if err := db.Where("key = ?", input.Key).First(&exist).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "achievement already exists"})
-		return
+// Начисления за ачивки идут в фоне; перед выходом процесса их нужно дождаться,
+// иначе полученная ачивка останется без опыта и уведомления
+var rewards sync.WaitGroup
+
+func goReward(db *gorm.DB, userID uint, ach models.Achievement, tier string) {
+	rewards.Add(1)
+	go func() {
+		defer rewards.Done()
+		rewardAchievement(db, userID, ach, tier)
+	}()
+}
+
+// WaitAchievementRewards ждёт фоновые начисления за полученные ачивки
+func WaitAchievementRewards(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		rewards.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	ach := models.Achievement{
-		Key:         input.Key,
-		Title:       input.Title,
-		Description: input.Description,
-		Icon:        input.Icon,
-		Condition:   datatypes.JSON(input.Condition),
+// rewardAchievement начисляет опыт за ачивку (или её уровень) и отправляет уведомление
+func rewardAchievement(db *gorm.DB, userID uint, ach models.Achievement, tier string) {
+	xp, key := ach.XP, fmt.Sprintf("achievement:%d", ach.ID)
+	if tier != "" {
+		if i := achievements.TierIndex(ach.Tiers, tier); i >= 0 {
+			xp = ach.Tiers[i].XP
+		}
+		key += ":" + tier
 	}
+	awardXP(db, userID, xp, "achievement", key)
+	notifyAchievementUnlocked(db, userID, ach, tier)
+}
 
-	if err := db.Create(&ach).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot create achievement"})
-		return
+// notifyAchievementUnlocked кладёт во входящие уведомление о полученной ачивке и отправляет push
+func notifyAchievementUnlocked(db *gorm.DB, userID uint, ach models.Achievement, tier string) {
+	payload := map[string]interface{}{
+		"key":   ach.Key,
+		"title": ach.Title,
+		"icon":  ach.Icon,
+	}
+	if tier != "" {
+		payload["tier"] = tier
 	}
 
-	c.JSON(http.StatusOK, gin.H{"achievement": ach})
+	notify.Send(db, notify.Event{
+		UserID:     userID,
+		Type:       notify.TypeAchievement,
+		TargetType: notify.TargetAchievement,
+		TargetID:   ach.ID,
+		Payload:    payload,
+	})
+
+	body := ach.Title
+	if tier != "" {
+		body += " (" + tier + ")"
+	}
+	push.SendToUsers(db, notify.TypeAchievement, []uint{userID}, push.Message{
+		Title: "Achievement unlocked",
+		Body:  body,
+		Data:  map[string]string{"type": notify.TypeAchievement, "achievement_id": strconv.Itoa(int(ach.ID))},
+	})
 }
-func AddAchievementToUser(c *gin.Context) {
+
+// Создание ачивки через API
+func CreateAchievement(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
 
 	var input struct {
-		UserID       uint   `json:"user_id" binding:"required"`
-		AchievementKey string `json:"key" binding:"required"`
+		Key         string                   `json:"key" binding:"required"`
+		Title       string                   `json:"title" binding:"required"`
+		Description string                   `json:"description"`
+		Icon        string                   `json:"icon"`
+		Condition   json.RawMessage          `json:"condition"`
+		XP          int                      `json:"xp"`
+		Tiers       []models.AchievementTier `json:"tiers"`
+		Category    string                   `json:"category"`
+		SortOrder   int                      `json:"sort_order"`
+		Hidden      bool                     `json:"hidden"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -144,41 +304,37 @@ func AddAchievementToUser(c *gin.Context) {
 		return
 	}
 
-	// Проверяем, существует ли ачивка
-	var ach models.Achievement
-	if err := db.Where("key = ?", input.AchievementKey).First(&ach).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Achievement not found"})
-		return
+	ach := models.Achievement{
+		Key:         input.Key,
+		Title:       input.Title,
+		Description: input.Description,
+		Icon:        input.Icon,
+		Condition:   datatypes.JSON(input.Condition),
+		XP:          input.XP,
+		Tiers:       datatypes.JSONSlice[models.AchievementTier](input.Tiers),
+		Category:    input.Category,
+		SortOrder:   input.SortOrder,
+		Hidden:      input.Hidden,
 	}
-
-	// Проверяем, существует ли уже UserAchievement
-	var userAch models.UserAchievement
-	err := db.Where("user_id = ? AND achievement_id = ?", input.UserID, ach.ID).First(&userAch).Error
-	if err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already has this achievement"})
+	if err := validateAchievement(&ach); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if err != gorm.ErrRecordNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
+	var exist models.Achievement
+	if err := db.Where("key = ?", input.Key).First(&exist).Error; err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "achievement already exists"})
 		return
 	}
 
-	// Создаём UserAchievement
-	userAch = models.UserAchievement{
-		UserID:        input.UserID,
-		AchievementID: ach.ID,
-		Progress:      0,
-		Unlocked:      false,
-	}
-
-	if err := db.Create(&userAch).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot create UserAchievement"})
+	if err := db.Create(&ach).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "cannot create achievement"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Achievement added to user", "user_achievement": userAch})
+	c.JSON(http.StatusOK, gin.H{"achievement": ach})
 }
+
 func GrantInfluencerAchievement(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
 
@@ -199,62 +355,120 @@ func GrantInfluencerAchievement(c *gin.Context) {
 			Key:         "influential",
 			Title:       "Влиятельный",
 			Description: "Предложить важные обновления в приложении",
-			Icon:        "https://cdn.ravell.app/achievements/influential.png", // можно свой URL
+			Category:    "community",
 		}
 		db.Create(&ach)
 	}
 
-	// Проверяем, есть ли уже UserAchievement
-	var userAch models.UserAchievement
-	err := db.Where("user_id = ? AND achievement_id = ?", input.UserID, ach.ID).First(&userAch).Error
-	if err == nil {
-		// Уже есть
+	userAch, granted, err := grantAchievement(db, input.UserID, ach, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot grant achievement"})
+		return
+	}
+	if !granted {
 		c.JSON(http.StatusConflict, gin.H{"error": "User already has this achievement"})
 		return
 	}
 
-	if err != gorm.ErrRecordNotFound {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "DB error"})
-		return
+	c.JSON(http.StatusOK, gin.H{"message": "Achievement granted", "user_achievement": userAch})
+}
+
+// Подсчет прогресса ачивки по её условию. ok = false, если условия нет (ачивка выдаётся вручную)
+func calculateProgress(db *gorm.DB, userID uint, ach models.Achievement) (progress float64, ok bool) {
+	rule, err := achievements.ForAchievement(ach)
+	if err != nil || rule == nil {
+		return 0, false
 	}
 
-	// Создаём с Unlocked = true
-	userAch = models.UserAchievement{
-		UserID:        input.UserID,
-		AchievementID: ach.ID,
-		Progress:      1,
-		Unlocked:      true,
+	if len(ach.Tiers) > 0 {
+		var value float64
+		value, err = rule.Measure(db, userID)
+		_, progress = achievements.TierProgress(ach.Tiers, value)
+	} else {
+		progress, err = rule.Evaluate(db, userID)
+	}
+	if err != nil {
+		log.Printf("Achievements: failed to evaluate %q for user %d: %v", ach.Key, userID, err)
+		return 0, false
 	}
+	return progress, true
+}
 
-	if err := db.Create(&userAch).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Cannot grant achievement"})
-		return
+// Какие метрики условий меняет каждое событие
+var achievementMetricsByEvent = map[string][]string{
+	events.StoryCreated:   {achievements.MetricStories, achievements.MetricBranchDepth, achievements.MetricHashtagsUsed, achievements.MetricViews},
+	events.ReplyReceived:  {achievements.MetricRepliesReceived},
+	events.StoryLiked:     {achievements.MetricLikesReceived},
+	events.UserFollowed:   {achievements.MetricFollowers},
+	events.CommentCreated: {achievements.MetricComments},
+	events.StreakUpdated:  {achievements.MetricStreak},
+}
+
+// SubscribeAchievements пересчитывает ачивки по доменным событиям
+func SubscribeAchievements(db *gorm.DB) {
+	types := []string{events.UserRegistered, events.EarlyAccessGranted}
+	for t := range achievementMetricsByEvent {
+		types = append(types, t)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Achievement granted", "user_achievement": userAch})
+	events.Subscribe(func(ev events.Event) {
+		switch ev.Type {
+		case events.UserRegistered, events.EarlyAccessGranted:
+			grantEarlyAccess(db, ev.UserID)
+		default:
+			evaluateAchievements(db, ev.UserID, achievementMetricsByEvent[ev.Type])
+		}
+	}, types...)
 }
 
-// Подсчет прогресса ачивки
-func calculateProgress(db *gorm.DB, userID uint, ach models.Achievement) float64 {
-	var cond map[string]interface{}
-	if err := json.Unmarshal(ach.Condition, &cond); err != nil {
-		return 0
+// evaluateAchievements пересчитывает ачивки пользователя, условия которых зависят
+// от изменившихся метрик. Полученные (для многоуровневых — до последнего уровня)
+// и отозванные пропускаются.
+func evaluateAchievements(db *gorm.DB, userID uint, changed []string) {
+	var completed []uint
+	db.Model(&models.UserAchievement{}).
+		Where("user_id = ? AND ((unlocked = ? AND progress >= 1) OR revoked = ?)", userID, true, true).
+		Pluck("achievement_id", &completed)
+
+	query := db.Where("condition IS NOT NULL AND archived_at IS NULL")
+	if len(completed) > 0 {
+		query = query.Where("id NOT IN ?", completed)
+	}
+
+	var all []models.Achievement
+	if err := query.Find(&all).Error; err != nil {
+		log.Printf("Achievements: failed to load achievements: %v", err)
+		return
 	}
 
-	switch cond["type"] {
-	case "story_count":
-		var count int64
-		db.Model(&models.Story{}).Where("user_id = ?", userID).Count(&count)
-		target, ok := cond["value"].(float64)
-		if !ok || target == 0 {
-			return 0
+	for _, ach := range all {
+		rule, err := achievements.ForAchievement(ach)
+		if err != nil || rule == nil || !dependsOn(rule, changed) {
+			continue
 		}
-		progress := float64(count) / target
-		if progress > 1 {
-			progress = 1
+
+		if _, err := evaluateAchievement(db, userID, ach, rule); err != nil {
+			log.Printf("Achievements: failed to evaluate %q for user %d: %v", ach.Key, userID, err)
 		}
-		return progress
 	}
+}
+
+func dependsOn(rule *achievements.Rule, changed []string) bool {
+	for _, m := range rule.Metrics() {
+		for _, c := range changed {
+			if m == c {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	return 0
+// grantEarlyAccess выдаёт early_access участникам раннего доступа
+func grantEarlyAccess(db *gorm.DB, userID uint) {
+	var profile models.Profile
+	if err := db.Select("is_early").Where("user_id = ?", userID).First(&profile).Error; err != nil || !profile.IsEarly {
+		return
+	}
+	UpdateAchievementProgress(db, userID, "early_access", 1)
 }
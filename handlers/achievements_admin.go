@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go_stories_api/achievements"
+	"go_stories_api/models"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	achievementIconDir     = "media/achievements"
+	achievementIconURL     = "/media/achievements/"
+	maxAchievementIconSize = 1 << 20
+	maxAchievementCategory = 50
+)
+
+// Допустимые форматы иконок (по содержимому файла) и расширения для них
+var achievementIconTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// validateAchievement проверяет ачивку перед сохранением: опыт, категорию, условие и уровни
+func validateAchievement(ach *models.Achievement) error {
+	if ach.XP < 0 {
+		return errors.New("xp must not be negative")
+	}
+	ach.Category = strings.TrimSpace(ach.Category)
+	if len(ach.Category) > maxAchievementCategory {
+		return fmt.Errorf("category must be at most %d characters", maxAchievementCategory)
+	}
+	_, err := achievements.ForAchievement(*ach)
+	return err
+}
+
+// grantAchievement выдаёт ачивку вручную; многоуровневую — до уровня tier (по умолчанию первого).
+// Снимает отзыв, если ачивку раньше забирали.
+// Возвращает false, если у пользователя она уже есть на этом уровне или выше.
+func grantAchievement(db *gorm.DB, userID uint, ach models.Achievement, tier string) (models.UserAchievement, bool, error) {
+	target := -1
+	if len(ach.Tiers) > 0 {
+		if tier == "" {
+			tier = ach.Tiers[0].Name
+		}
+		if target = achievements.TierIndex(ach.Tiers, tier); target < 0 {
+			return models.UserAchievement{}, false, fmt.Errorf("unknown tier %q", tier)
+		}
+	} else if tier != "" {
+		return models.UserAchievement{}, false, errors.New("achievement has no tiers")
+	}
+
+	var userAch models.UserAchievement
+	current, granted := -1, false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if userAch, err = lockUserAchievement(tx, userID, ach.ID); err != nil {
+			return err
+		}
+
+		current = achievements.TierIndex(ach.Tiers, userAch.Tier)
+		if userAch.Unlocked && current >= target {
+			return nil
+		}
+
+		unlock(&userAch)
+		userAch.Revoked = false
+		if target < 0 || target == len(ach.Tiers)-1 {
+			userAch.Progress = 1
+		}
+		if target >= 0 {
+			userAch.Tier = ach.Tiers[target].Name
+		}
+		granted = true
+		return tx.Save(&userAch).Error
+	})
+	if err != nil || !granted {
+		return userAch, false, err
+	}
+
+	if target < 0 {
+		goReward(db, userID, ach, "")
+	}
+	for i := current + 1; i <= target; i++ {
+		goReward(db, userID, ach, ach.Tiers[i].Name)
+	}
+	return userAch, true, nil
+}
+
+func findAchievement(c *gin.Context, db *gorm.DB) (models.Achievement, bool) {
+	var ach models.Achievement
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid achievement ID"})
+		return ach, false
+	}
+	if err := db.First(&ach, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Achievement not found"})
+		return ach, false
+	}
+	return ach, true
+}
+
+// ListAchievements — все ачивки для админки с числом получивших.
+// ?category= — фильтр, ?archived=true — вместе с архивными.
+func ListAchievements(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	query := db.Order("category, sort_order, id")
+	if category := c.Query("category"); category != "" {
+		query = query.Where("category = ?", category)
+	}
+	if c.Query("archived") != "true" {
+		query = query.Where("archived_at IS NULL")
+	}
+
+	var all []models.Achievement
+	if err := query.Find(&all).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch achievements"})
+		return
+	}
+
+	var counts []struct {
+		AchievementID uint
+		Count         int64
+	}
+	db.Model(&models.UserAchievement{}).
+		Select("achievement_id, COUNT(*) AS count").
+		Where("unlocked = ?", true).
+		Group("achievement_id").
+		Scan(&counts)
+	unlocked := make(map[uint]int64, len(counts))
+	for _, row := range counts {
+		unlocked[row.AchievementID] = row.Count
+	}
+
+	type adminAchievement struct {
+		models.Achievement
+		UnlockedCount int64 `json:"unlocked_count"`
+	}
+	result := make([]adminAchievement, len(all))
+	for i, ach := range all {
+		result[i] = adminAchievement{Achievement: ach, UnlockedCount: unlocked[ach.ID]}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"achievements": result, "count": len(result)})
+}
+
+// UpdateAchievement меняет переданные поля ачивки. Key не меняется — на него ссылается код.
+// После изменения условия или уровней запускается пересчёт прогресса по этой ачивке.
+func UpdateAchievement(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	ach, ok := findAchievement(c, db)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Title       *string                   `json:"title"`
+		Description *string                   `json:"description"`
+		Icon        *string                   `json:"icon"`
+		Condition   json.RawMessage           `json:"condition"` // null — снять условие (выдача вручную)
+		XP          *int                      `json:"xp"`
+		Tiers       *[]models.AchievementTier `json:"tiers"`
+		Category    *string                   `json:"category"`
+		SortOrder   *int                      `json:"sort_order"`
+		Hidden      *bool                     `json:"hidden"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Title != nil {
+		if strings.TrimSpace(*input.Title) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "title must not be empty"})
+			return
+		}
+		ach.Title = *input.Title
+	}
+	if input.Description != nil {
+		ach.Description = *input.Description
+	}
+	if input.Icon != nil {
+		ach.Icon = *input.Icon
+	}
+	if input.Condition != nil {
+		ach.Condition = nil
+		if trimmed := bytes.TrimSpace(input.Condition); string(trimmed) != "null" {
+			ach.Condition = datatypes.JSON(trimmed)
+		}
+	}
+	if input.XP != nil {
+		ach.XP = *input.XP
+	}
+	if input.Tiers != nil {
+		ach.Tiers = datatypes.JSONSlice[models.AchievementTier](*input.Tiers)
+	}
+	if input.Category != nil {
+		ach.Category = *input.Category
+	}
+	if input.SortOrder != nil {
+		ach.SortOrder = *input.SortOrder
+	}
+	if input.Hidden != nil {
+		ach.Hidden = *input.Hidden
+	}
+
+	if err := validateAchievement(&ach); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.Save(&ach).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update achievement"})
+		return
+	}
+
+	response := gin.H{"achievement": ach}
+	conditionChanged := input.Condition != nil || input.Tiers != nil
+	if conditionChanged && ach.ArchivedAt == nil && len(ach.Condition) > 0 {
+		if job, err := StartAchievementRecompute(db, &ach.ID); err == nil {
+			go RunAchievementRecompute(context.Background(), db, job.ID)
+			response["recompute"] = job
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ArchiveAchievement снимает ачивку с выдачи; у получивших она остаётся
+func ArchiveAchievement(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	ach, ok := findAchievement(c, db)
+	if !ok {
+		return
+	}
+	if ach.ArchivedAt == nil {
+		now := time.Now()
+		ach.ArchivedAt = &now
+		if err := db.Model(&ach).Update("archived_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to archive achievement"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"achievement": ach})
+}
+
+// UnarchiveAchievement возвращает ачивку в выдачу
+func UnarchiveAchievement(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	ach, ok := findAchievement(c, db)
+	if !ok {
+		return
+	}
+	if ach.ArchivedAt != nil {
+		ach.ArchivedAt = nil
+		if err := db.Model(&ach).Update("archived_at", nil).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unarchive achievement"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"achievement": ach})
+}
+
+// UploadAchievementIcon сохраняет иконку (multipart-поле icon) в media/achievements
+// и отдаёт её через /media. Прежняя загруженная иконка удаляется.
+func UploadAchievementIcon(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	ach, ok := findAchievement(c, db)
+	if !ok {
+		return
+	}
+
+	file, _, err := c.Request.FormFile("icon")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "icon file is required"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAchievementIconSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read icon"})
+		return
+	}
+	if len(data) > maxAchievementIconSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "icon must be at most 1 MB"})
+		return
+	}
+	ext, ok := achievementIconTypes[http.DetectContentType(data)]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "icon must be png, jpeg, webp or gif"})
+		return
+	}
+
+	if err := os.MkdirAll(achievementIconDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save icon"})
+		return
+	}
+	name := fmt.Sprintf("%d_%d%s", ach.ID, time.Now().UnixNano(), ext)
+	if err := os.WriteFile(filepath.Join(achievementIconDir, name), data, 0644); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save icon"})
+		return
+	}
+
+	previous := ach.Icon
+	ach.Icon = achievementIconURL + name
+	if err := db.Model(&ach).Update("icon", ach.Icon).Error; err != nil {
+		os.Remove(filepath.Join(achievementIconDir, name))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update achievement"})
+		return
+	}
+	if strings.HasPrefix(previous, achievementIconURL) {
+		os.Remove(filepath.Join(achievementIconDir, filepath.Base(previous)))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"achievement": ach})
+}
+
+// GrantAchievement выдаёт ачивку пользователю: {"user_id": 1, "tier": "silver"}
+func GrantAchievement(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	ach, ok := findAchievement(c, db)
+	if !ok {
+		return
+	}
+	if ach.ArchivedAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Achievement is archived"})
+		return
+	}
+
+	var input struct {
+		UserID uint   `json:"user_id" binding:"required"`
+		Tier   string `json:"tier"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db.Select("id").First(&user, input.UserID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	userAch, granted, err := grantAchievement(db, input.UserID, ach, input.Tier)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !granted {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already has this achievement"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Achievement granted", "user_achievement": userAch})
+}
+
+// RevokeAchievement забирает ачивку у пользователя: {"user_id": 1}. Запись остаётся с флагом
+// revoked, чтобы условие не выдало ачивку снова; вернуть её можно только через grant.
+// Начисленный за неё опыт не списывается, а при повторной выдаче не начисляется снова.
+func RevokeAchievement(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	ach, ok := findAchievement(c, db)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		UserID uint `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result := db.Model(&models.UserAchievement{}).
+		Where("user_id = ? AND achievement_id = ? AND unlocked = ?", input.UserID, ach.ID, true).
+		Updates(map[string]interface{}{
+			"revoked":     true,
+			"unlocked":    false,
+			"unlocked_at": nil,
+			"tier":        "",
+			"showcase":    0,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke achievement"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User does not have this achievement"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Achievement revoked"})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"go_stories_api/events"
 	"go_stories_api/models"
 	"go_stories_api/utils"
 	"net/http"
@@ -66,6 +67,8 @@ func Register(c *gin.Context) {
 		return
 	}
 
+	events.Publish(events.Event{Type: events.UserRegistered, UserID: user.ID})
+
 	// ГЕНЕРАЦИЯ ТОКЕНОВ СРАЗУ (без OTP)
 	tokens, err := utils.GenerateJWTToken(user.ID)
 	if err != nil {
@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"go_stories_api/events"
 	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/push"
 	"net/http"
 	"strconv"
 
@@ -20,45 +23,155 @@ func GetAllComments(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
         return
     }
+    for i := range comments {
+        hideDeletedAuthor(&comments[i])
+    }
 
     c.JSON(http.StatusOK, gin.H{
         "comments": comments,
         "count":    len(comments),
     })
 }
+// Максимальная глубина веток: столько уровней ответов отдаём, глубже ответить нельзя
+const maxCommentDepth = 10
+
+// GetComments отдаёт дерево комментариев истории.
+// Пагинация по комментариям верхнего уровня, sort=top|new.
 func GetComments(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
 	
-	storyID, err := strconv.Atoi(c.Param("story_id"))
+	storyID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid story ID"})
 		return
 	}
 
-	var comments []models.Comment
-	result := db.Preload("User").Preload("User.Profile").
-		Where("story_id = ?", storyID).
-		Order("created_at DESC").
-		Find(&comments)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	sort := c.DefaultQuery("sort", "top")
+	var order string
+	switch sort {
+	case "top":
+		order = "likes_count DESC, reply_count DESC, created_at DESC"
+	case "new":
+		order = "created_at DESC"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be top or new"})
+		return
+	}
+
+	var total int64
+	db.Model(&models.Comment{}).Where("story_id = ? AND parent_id IS NULL", storyID).Count(&total)
+
+	var roots []models.Comment
+	result := db.Preload("User").Preload("User.Profile").Preload("Mentions").
+		Where("story_id = ? AND parent_id IS NULL", storyID).
+		Order(order).
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&roots)
 
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch comments"})
 		return
 	}
 
+	// Ответы подгружаем слоями: дети текущего уровня -> следующий уровень
+	children := make(map[uint][]models.Comment)
+	allIDs := make([]uint, 0, len(roots))
+	level := make([]uint, 0, len(roots))
+	for _, root := range roots {
+		level = append(level, root.ID)
+	}
+	allIDs = append(allIDs, level...)
+
+	for depth := 0; depth < maxCommentDepth && len(level) > 0; depth++ {
+		var replies []models.Comment
+		if err := db.Preload("User").Preload("User.Profile").Preload("Mentions").
+			Where("parent_id IN ?", level).
+			Order(order).
+			Find(&replies).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch replies"})
+			return
+		}
+
+		level = level[:0]
+		for _, reply := range replies {
+			children[*reply.ParentID] = append(children[*reply.ParentID], reply)
+			level = append(level, reply.ID)
+		}
+		allIDs = append(allIDs, level...)
+	}
+
+	liked := make(map[uint]bool)
+	if uID, exists := c.Get("user_id"); exists && len(allIDs) > 0 {
+		var likedIDs []uint
+		db.Model(&models.CommentLike{}).
+			Where("user_id = ? AND comment_id IN ?", uID.(uint), allIDs).
+			Pluck("comment_id", &likedIDs)
+		for _, id := range likedIDs {
+			liked[id] = true
+		}
+	}
+
+	tree := buildCommentTree(roots, children, liked)
+
 	c.JSON(http.StatusOK, gin.H{
-		"comments": comments,
-		"count":    len(comments),
+		"comments": tree,
+		"count":    len(tree),
+		"total":    total,
+		"page":     page,
+		"sort":     sort,
 	})
 }
 
+func buildCommentTree(list []models.Comment, children map[uint][]models.Comment, liked map[uint]bool) []models.Comment {
+	for i := range list {
+		hideDeletedAuthor(&list[i])
+		list[i].Liked = liked[list[i].ID]
+		list[i].Replies = buildCommentTree(children[list[i].ID], children, liked)
+	}
+	return list
+}
+
+// hideDeletedAuthor убирает автора у "надгробия" удалённого комментария
+func hideDeletedAuthor(comment *models.Comment) {
+	if comment.IsDeleted {
+		comment.UserID = 0
+		comment.User = models.User{}
+	}
+}
+
+// commentDepth — уровень комментария в ветке (0 — верхний уровень)
+func commentDepth(db *gorm.DB, commentID uint) (int, error) {
+	var depth int
+	err := db.Raw(`
+		WITH RECURSIVE up AS (
+			SELECT id, parent_id, 0 AS depth FROM comments WHERE id = ?
+			UNION ALL
+			SELECT c.id, c.parent_id, up.depth + 1
+			FROM comments c JOIN up ON c.id = up.parent_id
+			WHERE up.depth < ?
+		)
+		SELECT COALESCE(MAX(depth), 0) FROM up`, commentID, maxCommentDepth).Scan(&depth).Error
+	return depth, err
+}
+
 func CreateComment(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
 	userID := c.MustGet("user_id").(uint)
 	
 	var req struct {
-		StoryID uint   `json:"story_id" binding:"required"`
-		Content string `json:"content" binding:"required"`
+		StoryID  uint   `json:"story_id" binding:"required"`
+		ParentID *uint  `json:"parent_id"`
+		Content  string `json:"content" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -73,19 +186,101 @@ func CreateComment(c *gin.Context) {
 		return
 	}
 
+	// Ответ на комментарий: родитель должен быть из той же истории и не удалён
+	var parent models.Comment
+	if req.ParentID != nil {
+		if err := db.First(&parent, *req.ParentID).Error; err != nil || parent.IsDeleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Parent comment not found"})
+			return
+		}
+		if parent.StoryID != req.StoryID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent comment belongs to another story"})
+			return
+		}
+		depth, err := commentDepth(db, parent.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+			return
+		}
+		if depth+1 > maxCommentDepth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reply is nested too deep"})
+			return
+		}
+	}
+
 	comment := models.Comment{
-		UserID:  userID,
-		StoryID: req.StoryID,
-		Content: req.Content,
+		UserID:   userID,
+		StoryID:  req.StoryID,
+		ParentID: req.ParentID,
+		Content:  req.Content,
 	}
 
-	if err := db.Create(&comment).Error; err != nil {
+	var mentioned []uint
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&comment).Error; err != nil {
+			return err
+		}
+		if comment.ParentID != nil {
+			if err := tx.Model(&models.Comment{}).Where("id = ?", *comment.ParentID).
+				Update("reply_count", gorm.Expr("reply_count + 1")).Error; err != nil {
+				return err
+			}
+		}
+		var err error
+		_, mentioned, err = syncMentions(tx, userID, mentionTarget{CommentID: &comment.ID},
+			mentionField{Name: "content", Text: comment.Content})
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
 		return
 	}
 
+	go notifyMentions(db, userID, mentioned, mentionTarget{CommentID: &comment.ID})
+
+	go notify.Send(db, notify.Event{
+		UserID:     story.UserID,
+		ActorID:    userID,
+		Type:       notify.TypeComment,
+		TargetType: notify.TargetStory,
+		TargetID:   story.ID,
+		Payload:    map[string]interface{}{"comment_id": comment.ID},
+	})
+	if req.ParentID != nil {
+		go notify.Send(db, notify.Event{
+			UserID:     parent.UserID,
+			ActorID:    userID,
+			Type:       notify.TypeReply,
+			TargetType: notify.TargetComment,
+			TargetID:   parent.ID,
+			Payload:    map[string]interface{}{"comment_id": comment.ID, "story_id": story.ID},
+		})
+	}
+
 	// Загружаем связанные данные
-	db.Preload("User").Preload("User.Profile").First(&comment, comment.ID)
+	db.Preload("User").Preload("User.Profile").Preload("Mentions").First(&comment, comment.ID)
+
+	// --- Пуши автору истории и автору родительского комментария ---
+	pushData := map[string]string{"story_id": strconv.Itoa(int(story.ID)), "comment_id": strconv.Itoa(int(comment.ID))}
+	if story.UserID != userID {
+		go push.SendToUsers(db, notify.TypeComment, []uint{story.UserID}, push.Message{
+			Title: "@" + comment.User.Username + " commented on your story",
+			Body:  comment.Content,
+			Data:  withType(pushData, notify.TypeComment),
+		})
+	}
+	if req.ParentID != nil && parent.UserID != userID {
+		go push.SendToUsers(db, notify.TypeReply, []uint{parent.UserID}, push.Message{
+			Title: "@" + comment.User.Username + " replied to your comment",
+			Body:  comment.Content,
+			Data:  withType(pushData, notify.TypeReply),
+		})
+	}
+
+	go publishNewComment(db, comment)
+
+	trackStreak(db, userID, "comment", comment.CreatedAt)
+	events.Publish(events.Event{Type: events.CommentCreated, UserID: userID, TargetID: comment.ID})
 
 	c.JSON(http.StatusCreated, comment)
 }
@@ -101,7 +296,7 @@ func UpdateComment(c *gin.Context) {
 	}
 
 	var comment models.Comment
-	if err := db.First(&comment, commentID).Error; err != nil {
+	if err := db.First(&comment, commentID).Error; err != nil || comment.IsDeleted {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
 		return
 	}
@@ -122,11 +317,23 @@ func UpdateComment(c *gin.Context) {
 	}
 
 	comment.Content = req.Content
-	if err := db.Save(&comment).Error; err != nil {
+	var mentioned []uint
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&comment).Error; err != nil {
+			return err
+		}
+		var err error
+		comment.Mentions, mentioned, err = syncMentions(tx, userID, mentionTarget{CommentID: &comment.ID},
+			mentionField{Name: "content", Text: comment.Content})
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
 		return
 	}
 
+	go notifyMentions(db, userID, mentioned, mentionTarget{CommentID: &comment.ID})
+
 	c.JSON(http.StatusOK, comment)
 }
 
@@ -141,7 +348,7 @@ func DeleteComment(c *gin.Context) {
 	}
 
 	var comment models.Comment
-	if err := db.First(&comment, commentID).Error; err != nil {
+	if err := db.First(&comment, commentID).Error; err != nil || comment.IsDeleted {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
 		return
 	}
@@ -152,10 +359,123 @@ func DeleteComment(c *gin.Context) {
 		return
 	}
 
-	if err := db.Delete(&comment).Error; err != nil {
+	tombstoned := comment.ReplyCount > 0
+	if err := db.Transaction(func(tx *gorm.DB) error { return deleteComment(tx, comment) }); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted successfully"})
+	go publishStoryCounters(db, comment.StoryID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Comment deleted successfully",
+		"tombstoned": tombstoned,
+	})
+}
+
+// deleteComment удаляет комментарий. Если на него есть ответы — оставляет "надгробие"
+// без текста, чтобы не рвать ветку. Удалённый родитель без ответов удаляется следом.
+func deleteComment(tx *gorm.DB, comment models.Comment) error {
+	if err := tx.Where("comment_id = ?", comment.ID).Delete(&models.Mention{}).Error; err != nil {
+		return err
+	}
+
+	if comment.ReplyCount > 0 {
+		return tx.Model(&comment).Updates(map[string]interface{}{
+			"is_deleted": true,
+			"content":    "",
+		}).Error
+	}
+
+	if err := tx.Where("comment_id = ?", comment.ID).Delete(&models.CommentLike{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Delete(&comment).Error; err != nil {
+		return err
+	}
+
+	if comment.ParentID == nil {
+		return nil
+	}
+
+	if err := tx.Model(&models.Comment{}).Where("id = ?", *comment.ParentID).
+		Update("reply_count", gorm.Expr("GREATEST(reply_count - 1, 0)")).Error; err != nil {
+		return err
+	}
+
+	var parent models.Comment
+	if err := tx.First(&parent, *comment.ParentID).Error; err != nil {
+		return nil
+	}
+	if parent.IsDeleted && parent.ReplyCount == 0 {
+		return deleteComment(tx, parent)
+	}
+	return nil
+}
+
+func LikeComment(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	commentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	var comment models.Comment
+	if err := db.First(&comment, commentID).Error; err != nil || comment.IsDeleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return
+	}
+
+	liked := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existingLike models.CommentLike
+		if err := tx.Where("user_id = ? AND comment_id = ?", userID, comment.ID).First(&existingLike).Error; err == nil {
+			if err := tx.Delete(&existingLike).Error; err != nil {
+				return err
+			}
+			return tx.Model(&comment).Update("likes_count", gorm.Expr("GREATEST(likes_count - 1, 0)")).Error
+		}
+
+		liked = true
+		if err := tx.Create(&models.CommentLike{UserID: userID, CommentID: comment.ID}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&comment).Update("likes_count", gorm.Expr("likes_count + 1")).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to like comment"})
+		return
+	}
+
+	if liked {
+		go notify.Send(db, notify.Event{
+			UserID:     comment.UserID,
+			ActorID:    userID,
+			Type:       notify.TypeLike,
+			TargetType: notify.TargetComment,
+			TargetID:   comment.ID,
+			Payload:    map[string]interface{}{"story_id": comment.StoryID},
+		})
+	}
+
+	var likesCount int64
+	db.Model(&models.CommentLike{}).Where("comment_id = ?", comment.ID).Count(&likesCount)
+
+	c.JSON(http.StatusOK, gin.H{
+		"liked":       liked,
+		"message":     "Operation successful",
+		"likes_count": likesCount,
+	})
+}
+
+// withType копирует данные пуша, добавляя тип события
+func withType(data map[string]string, eventType string) map[string]string {
+	out := map[string]string{"type": eventType}
+	for k, v := range data {
+		out[k] = v
+	}
+	return out
 }
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"go_stories_api/blocks"
+	"go_stories_api/mail"
+	"go_stories_api/models"
+	"go_stories_api/prefs"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Дайджест уходит по понедельникам с 9 утра по времени пользователя
+const (
+	digestWeekday   = time.Monday
+	digestHour      = 9
+	digestInterval  = 6 * 24 * time.Hour // защита от повторной отправки на той же неделе
+	digestBatchSize = 200
+	digestTopN      = 5
+)
+
+type digestStory struct {
+	ID       uint
+	Title    string
+	Username string
+}
+
+// digestData — содержимое дайджеста за неделю
+type digestData struct {
+	Stories        []digestStory // лучшие истории от тех, на кого подписан
+	Replies        []digestStory // новые ответы на истории пользователя
+	RepliesCount   int64
+	Followers      []string
+	FollowersCount int64
+	StreakCount    int
+}
+
+func (d digestData) empty() bool {
+	return len(d.Stories) == 0 && d.RepliesCount == 0 && d.FollowersCount == 0
+}
+
+// StartDigestWorker раз в час проверяет, кому пора отправить еженедельный дайджест
+func StartDigestWorker(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			SendWeeklyDigests(ctx, db, time.Now())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// SendWeeklyDigests ставит в очередь дайджесты всем, у кого в их часовом поясе наступило время отправки
+func SendWeeklyDigests(ctx context.Context, db *gorm.DB, now time.Time) {
+	if !mail.Enabled() {
+		return // иначе дайджесты будут отмечены отправленными, хотя писем никто не отправит
+	}
+
+	queued := 0
+	var lastID uint
+
+	for ctx.Err() == nil {
+		var users []models.User
+		if err := db.Preload("Profile").
+			Where("id > ? AND email <> ''", lastID).
+			Order("id").
+			Limit(digestBatchSize).
+			Find(&users).Error; err != nil {
+			log.Printf("Digest: failed to load users: %v", err)
+			return
+		}
+		if len(users) == 0 {
+			break
+		}
+		lastID = users[len(users)-1].ID
+
+		ids := make([]uint, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		allowed := make(map[uint]bool)
+		for _, id := range prefs.FilterUsers(db, ids, "digest", prefs.ChannelEmail) {
+			allowed[id] = true
+		}
+
+		for _, user := range users {
+			if !allowed[user.ID] || !digestDue(user.Profile, now) {
+				continue
+			}
+			sent, err := sendDigest(db, user, now)
+			if err != nil {
+				log.Printf("Digest: failed for user %d: %v", user.ID, err)
+				continue
+			}
+			if sent {
+				queued++
+			}
+		}
+	}
+
+	if queued > 0 {
+		log.Printf("Digest: queued %d weekly digests", queued)
+	}
+}
+
+// digestDue — понедельник, 9 утра или позже по часовому поясу пользователя
+func digestDue(profile models.Profile, now time.Time) bool {
+	loc, err := time.LoadLocation(profile.TimeZone)
+	if err != nil || profile.TimeZone == "" {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	return local.Weekday() == digestWeekday && local.Hour() >= digestHour
+}
+
+// sendDigest собирает и ставит в очередь дайджест. Возвращает false, если на этой неделе
+// дайджест уже был или рассказать нечего.
+func sendDigest(db *gorm.DB, user models.User, now time.Time) (bool, error) {
+	settings, err := prefs.Settings(db, user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	// Помечаем неделю заранее — так два экземпляра сервера не отправят письмо дважды
+	result := db.Model(&models.NotificationSettings{}).
+		Where("id = ? AND (last_digest_at IS NULL OR last_digest_at < ?)", settings.ID, now.Add(-digestInterval)).
+		Update("last_digest_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	since := now.AddDate(0, 0, -7)
+	if settings.LastDigestAt != nil && settings.LastDigestAt.After(since) {
+		since = *settings.LastDigestAt
+	}
+
+	data, err := buildDigest(db, user, since)
+	if err != nil {
+		return false, err
+	}
+	if data.empty() {
+		return false, nil
+	}
+
+	err = mail.NotifyUser(db, user.ID, "digest", "digest", map[string]interface{}{
+		"Username":       user.Username,
+		"Stories":        data.Stories,
+		"Replies":        data.Replies,
+		"RepliesCount":   data.RepliesCount,
+		"Followers":      data.Followers,
+		"FollowersCount": data.FollowersCount,
+		"StreakCount":    data.StreakCount,
+	})
+	return err == nil, err
+}
+
+func buildDigest(db *gorm.DB, user models.User, since time.Time) (digestData, error) {
+	data := digestData{StreakCount: user.Profile.StreakCount}
+
+	// Лучшие истории авторов, на которых подписан пользователь: недельный рейтинг трендов,
+	// затем просмотры
+	if err := db.Table("stories").
+		Select("stories.id, stories.title, users.username").
+		Joins("JOIN users ON users.id = stories.user_id").
+		Joins("JOIN subscriptions ON subscriptions.following_id = stories.user_id AND subscriptions.follower_id = ?", user.ID).
+		Joins("LEFT JOIN trending_stories ON trending_stories.story_id = stories.id AND trending_stories.period = ?", "7d").
+		Where("stories.created_at >= ?", since).
+		Where("stories.user_id NOT IN (?)", blocks.UserIDs(db, user.ID)).
+		Order("COALESCE(trending_stories.score, 0) DESC, stories.views DESC, stories.id DESC").
+		Limit(digestTopN).
+		Scan(&data.Stories).Error; err != nil {
+		return data, err
+	}
+
+	// Новые ответы на ветки пользователя
+	replies := db.Table("stories").
+		Joins("JOIN stories AS parents ON parents.id = stories.reply_to").
+		Where("parents.user_id = ? AND stories.user_id <> ? AND stories.created_at >= ?", user.ID, user.ID, since).
+		Where("stories.user_id NOT IN (?)", blocks.UserIDs(db, user.ID))
+	if err := replies.Session(&gorm.Session{}).Count(&data.RepliesCount).Error; err != nil {
+		return data, err
+	}
+	if data.RepliesCount > 0 {
+		if err := replies.Session(&gorm.Session{}).
+			Select("stories.id, stories.title, users.username").
+			Joins("JOIN users ON users.id = stories.user_id").
+			Order("stories.created_at DESC").
+			Limit(digestTopN).
+			Scan(&data.Replies).Error; err != nil {
+			return data, err
+		}
+	}
+
+	// Новые подписчики
+	followers := db.Table("subscriptions").
+		Where("subscriptions.following_id = ? AND subscriptions.created_at >= ?", user.ID, since)
+	if err := followers.Session(&gorm.Session{}).Count(&data.FollowersCount).Error; err != nil {
+		return data, err
+	}
+	if data.FollowersCount > 0 {
+		if err := followers.Session(&gorm.Session{}).
+			Joins("JOIN users ON users.id = subscriptions.follower_id").
+			Order("subscriptions.created_at DESC").
+			Limit(digestTopN).
+			Pluck("users.username", &data.Followers).Error; err != nil {
+			return data, err
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"go_stories_api/models"
+	"go_stories_api/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// hashtagFromParam загружает хештег по :id, отвечая 400/404 при ошибке
+func hashtagFromParam(c *gin.Context, db *gorm.DB) (models.Hashtag, bool) {
+	var hashtag models.Hashtag
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag ID"})
+		return hashtag, false
+	}
+
+	if err := db.First(&hashtag, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hashtag not found"})
+		return hashtag, false
+	}
+
+	return hashtag, true
+}
+
+// hashtagNameTaken проверяет, занято ли имя другим тегом или синонимом
+func hashtagNameTaken(db *gorm.DB, name string) bool {
+	_, err := lookupHashtag(db, name)
+	return err == nil
+}
+
+// RenameHashtag переименовывает тег; старое имя остаётся синонимом
+func RenameHashtag(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	hashtag, ok := hashtagFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := utils.NormalizeHashtag(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag name"})
+		return
+	}
+	if name == hashtag.Name {
+		c.JSON(http.StatusOK, hashtag)
+		return
+	}
+
+	// Синоним этого же тега можно "повысить" до основного имени
+	var ownAlias models.HashtagAlias
+	isOwnAlias := db.Where("name = ? AND hashtag_id = ?", name, hashtag.ID).First(&ownAlias).Error == nil
+	if !isOwnAlias && hashtagNameTaken(db, name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Hashtag with this name already exists, use merge instead"})
+		return
+	}
+
+	oldName := hashtag.Name
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if isOwnAlias {
+			if err := tx.Delete(&ownAlias).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&hashtag).Update("name", name).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.HashtagAlias{Name: oldName, HashtagID: hashtag.ID}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename hashtag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Hashtag renamed successfully",
+		"hashtag":  hashtag,
+		"old_name": oldName,
+	})
+}
+
+// MergeHashtag переносит все связи тега :id в target_id и удаляет исходный тег
+func MergeHashtag(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	source, ok := hashtagFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TargetID uint `json:"target_id" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.TargetID == source.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot merge hashtag into itself"})
+		return
+	}
+
+	var target models.Hashtag
+	if err := db.First(&target, req.TargetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target hashtag not found"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// 1. Истории: удаляем дубли, остальные перевешиваем на target
+		if err := tx.Exec(`
+			DELETE FROM story_hashtags
+			WHERE hashtag_id = ? AND story_id IN (SELECT story_id FROM story_hashtags WHERE hashtag_id = ?)`,
+			source.ID, target.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE story_hashtags SET hashtag_id = ? WHERE hashtag_id = ?", target.ID, source.ID).Error; err != nil {
+			return err
+		}
+
+		// 2. Подписки: то же самое
+		if err := tx.Exec(`
+			DELETE FROM hashtag_follows
+			WHERE hashtag_id = ? AND user_id IN (SELECT user_id FROM hashtag_follows WHERE hashtag_id = ?)`,
+			source.ID, target.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("UPDATE hashtag_follows SET hashtag_id = ? WHERE hashtag_id = ?", target.ID, source.ID).Error; err != nil {
+			return err
+		}
+
+		// 3. Синонимы источника теперь указывают на target, а его имя становится синонимом
+		if err := tx.Exec("UPDATE hashtag_aliases SET hashtag_id = ? WHERE hashtag_id = ?", target.ID, source.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM trending_hashtags WHERE hashtag_id = ?", source.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&source).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.HashtagAlias{Name: source.Name, HashtagID: target.ID}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge hashtags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Hashtags merged successfully",
+		"hashtag": target,
+		"merged":  source.Name,
+	})
+}
+
+func GetHashtagAliases(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	hashtag, ok := hashtagFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	var aliases []models.HashtagAlias
+	if err := db.Where("hashtag_id = ?", hashtag.ID).Order("name ASC").Find(&aliases).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch aliases"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hashtag": hashtag,
+		"aliases": aliases,
+		"count":   len(aliases),
+	})
+}
+
+func CreateHashtagAlias(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	hashtag, ok := hashtagFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := utils.NormalizeHashtag(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag name"})
+		return
+	}
+
+	if hashtagNameTaken(db, name) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Hashtag or alias with this name already exists, use merge instead"})
+		return
+	}
+
+	alias := models.HashtagAlias{
+		Name:      name,
+		HashtagID: hashtag.ID,
+	}
+
+	if err := db.Create(&alias).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alias"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, alias)
+}
+
+func DeleteHashtagAlias(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	hashtagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag ID"})
+		return
+	}
+
+	aliasID, err := strconv.Atoi(c.Param("alias_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alias ID"})
+		return
+	}
+
+	result := db.Where("id = ? AND hashtag_id = ?", aliasID, hashtagID).Delete(&models.HashtagAlias{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alias"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alias not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alias deleted successfully"})
+}
+
+// BanHashtag запрещает тег и отвязывает его от всех историй
+func BanHashtag(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	hashtag, ok := hashtagFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&hashtag).Update("is_banned", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM story_hashtags WHERE hashtag_id = ?", hashtag.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM hashtag_follows WHERE hashtag_id = ?", hashtag.ID).Error; err != nil {
+			return err
+		}
+		return tx.Exec("DELETE FROM trending_hashtags WHERE hashtag_id = ?", hashtag.ID).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ban hashtag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Hashtag banned successfully",
+		"hashtag": hashtag,
+	})
+}
+
+func UnbanHashtag(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	hashtag, ok := hashtagFromParam(c, db)
+	if !ok {
+		return
+	}
+
+	if err := db.Model(&hashtag).Update("is_banned", false).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unban hashtag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Hashtag unbanned successfully",
+		"hashtag": hashtag,
+	})
+}
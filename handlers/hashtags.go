@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 	"go_stories_api/models"
+	"go_stories_api/utils"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -39,6 +41,15 @@ func DeleteHashtag(c *gin.Context) {
 		if err := tx.Exec("DELETE FROM story_hashtags WHERE hashtag_id = ?", id).Error; err != nil {
 			return err
 		}
+		if err := tx.Exec("DELETE FROM hashtag_follows WHERE hashtag_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM hashtag_aliases WHERE hashtag_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM trending_hashtags WHERE hashtag_id = ?", id).Error; err != nil {
+			return err
+		}
 
 		// 2. Удаляем сам хештег
 		if err := tx.Delete(&hashtag).Error; err != nil {
@@ -64,7 +75,7 @@ func GetHashtags(c *gin.Context) {
 	db := c.MustGet("db").(*gorm.DB)
 	
 	var hashtags []models.Hashtag
-	result := db.Order("name ASC").Find(&hashtags)
+	result := db.Where("is_banned = ?", false).Order("name ASC").Find(&hashtags)
 
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch hashtags"})
@@ -89,15 +100,24 @@ func CreateHashtag(c *gin.Context) {
 		return
 	}
 
-	// Проверяем существование хештега
-	var existingHashtag models.Hashtag
-	if err := db.Where("name = ?", req.Name).First(&existingHashtag).Error; err == nil {
-		c.JSON(http.StatusConflict, gin.H{"error": "Hashtag already exists"})
+	name := utils.NormalizeHashtag(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag name"})
+		return
+	}
+
+	// Проверяем существование хештега (в том числе как синонима)
+	if existingHashtag, err := lookupHashtag(db, name); err == nil {
+		if existingHashtag.IsBanned {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Hashtag is banned"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Hashtag already exists", "hashtag": existingHashtag})
 		return
 	}
 
 	hashtag := models.Hashtag{
-		Name: req.Name,
+		Name: name,
 	}
 
 	if err := db.Create(&hashtag).Error; err != nil {
@@ -118,7 +138,7 @@ func GetHashtagStories(c *gin.Context) {
 	}
 
 	var hashtag models.Hashtag
-	if err := db.First(&hashtag, hashtagID).Error; err != nil {
+	if err := db.Where("is_banned = ?", false).First(&hashtag, hashtagID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Hashtag not found"})
 		return
 	}
@@ -135,6 +155,7 @@ func GetHashtagStories(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stories"})
 		return
 	}
+	attachStoryBadges(db, stories)
 
 	c.JSON(http.StatusOK, gin.H{
 		"hashtag": hashtag,
@@ -142,3 +163,174 @@ func GetHashtagStories(c *gin.Context) {
 		"count":   len(stories),
 	})
 }
+
+func FollowHashtag(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	hashtagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag ID"})
+		return
+	}
+
+	var hashtag models.Hashtag
+	if err := db.Where("is_banned = ?", false).First(&hashtag, hashtagID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hashtag not found"})
+		return
+	}
+
+	// Проверка существующей подписки
+	var existing models.HashtagFollow
+	if err := db.Where("user_id = ? AND hashtag_id = ?", userID, hashtag.ID).
+		First(&existing).Error; err == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Already following this hashtag"})
+		return
+	}
+
+	follow := models.HashtagFollow{
+		UserID:    userID,
+		HashtagID: hashtag.ID,
+	}
+
+	if err := db.Create(&follow).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to follow hashtag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Followed hashtag successfully",
+		"hashtag": hashtag,
+	})
+}
+
+func UnfollowHashtag(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	hashtagID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hashtag ID"})
+		return
+	}
+
+	result := db.Where("user_id = ? AND hashtag_id = ?", userID, hashtagID).Delete(&models.HashtagFollow{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unfollow hashtag"})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not following this hashtag"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully unfollowed hashtag"})
+}
+
+// getFollowedHashtags возвращает хештеги, на которые подписан пользователь
+func getFollowedHashtags(db *gorm.DB, userID uint) []models.Hashtag {
+	hashtags := make([]models.Hashtag, 0)
+	db.Joins("JOIN hashtag_follows ON hashtag_follows.hashtag_id = hashtags.id").
+		Where("hashtag_follows.user_id = ?", userID).
+		Order("hashtags.name ASC").
+		Find(&hashtags)
+	return hashtags
+}
+
+func GetFollowedHashtags(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	hashtags := getFollowedHashtags(db, uint(userID))
+
+	c.JSON(http.StatusOK, gin.H{
+		"hashtags": hashtags,
+		"count":    len(hashtags),
+	})
+}
+
+var errHashtagBanned = errors.New("hashtag is banned")
+
+// lookupHashtag ищет хештег по нормализованному имени с учётом синонимов.
+// Возвращает gorm.ErrRecordNotFound, если такого тега нет.
+func lookupHashtag(tx *gorm.DB, name string) (models.Hashtag, error) {
+	var hashtag models.Hashtag
+	err := tx.Where("name = ?", name).First(&hashtag).Error
+	if err == gorm.ErrRecordNotFound {
+		var alias models.HashtagAlias
+		if err := tx.Where("name = ?", name).First(&alias).Error; err != nil {
+			return hashtag, err
+		}
+		err = tx.First(&hashtag, alias.HashtagID).Error
+	}
+	return hashtag, err
+}
+
+// findOrCreateHashtag возвращает канонический хештег по нормализованному имени,
+// создавая его при необходимости. Забаненные теги не возвращаются.
+func findOrCreateHashtag(tx *gorm.DB, name string) (models.Hashtag, error) {
+	hashtag, err := lookupHashtag(tx, name)
+	if err == gorm.ErrRecordNotFound {
+		hashtag = models.Hashtag{Name: name}
+		err = tx.Create(&hashtag).Error
+	}
+	if err != nil {
+		return hashtag, err
+	}
+	if hashtag.IsBanned {
+		return hashtag, errHashtagBanned
+	}
+	return hashtag, nil
+}
+
+// syncStoryHashtags приводит inline-хештеги истории в соответствие с #тегами в тексте.
+// Хештеги, привязанные явно через hashtag_ids, не трогаем.
+func syncStoryHashtags(tx *gorm.DB, storyID uint, title, content string) error {
+	wanted := make(map[uint]bool)
+	for _, name := range utils.ExtractHashtags(title, content) {
+		hashtag, err := findOrCreateHashtag(tx, name)
+		if err == errHashtagBanned {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		wanted[hashtag.ID] = true
+	}
+
+	var links []models.StoryHashtag
+	if err := tx.Where("story_id = ?", storyID).Find(&links).Error; err != nil {
+		return err
+	}
+
+	linked := make(map[uint]bool)
+	for _, link := range links {
+		linked[link.HashtagID] = true
+		if link.Inline && !wanted[link.HashtagID] {
+			if err := tx.Delete(&link).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	for hashtagID := range wanted {
+		if linked[hashtagID] {
+			continue
+		}
+		if err := tx.Create(&models.StoryHashtag{
+			StoryID:   storyID,
+			HashtagID: hashtagID,
+			Inline:    true,
+		}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"context"
+	"go_stories_api/models"
+	"go_stories_api/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Периоды таблиц лидеров: календарные неделя и месяц (UTC) и всё время.
+// Значение — SQL-выражение начала периода.
+var leaderboardPeriods = map[string]string{
+	"week":  "date_trunc('week', NOW())",
+	"month": "date_trunc('month', NOW())",
+	"all":   "'-infinity'::timestamptz",
+}
+
+const leaderboardTopN = 100
+
+// Источники очков по историям: (user_id автора, story_id, weight).
+// Такие метрики можно считать и по хештегам — через теги истории.
+var leaderboardStoryActivity = map[string]func(period string) string{
+	// Ответы других пользователей на истории автора
+	"replies_received": func(string) string {
+		return `SELECT parent.user_id, parent.id AS story_id, 1.0 AS weight
+			FROM stories reply JOIN stories parent ON parent.id = reply.reply_to
+			WHERE reply.user_id <> parent.user_id AND reply.created_at >= @since`
+	},
+	// За период — уникальные просмотры из post_views, за всё время — счётчик истории
+	"views": func(period string) string {
+		if period == "all" {
+			return `SELECT user_id, id AS story_id, views::float AS weight FROM stories WHERE views > 0`
+		}
+		return `SELECT stories.user_id, stories.id AS story_id, 1.0 AS weight
+			FROM post_views JOIN stories ON stories.id = post_views.post_id
+			WHERE post_views.created_at >= @since`
+	},
+	// Время репостов не хранится, поэтому за период считаются репосты историй, опубликованных в нём
+	"shares": func(string) string {
+		return `SELECT user_id, id AS story_id, shares::float AS weight
+			FROM stories WHERE shares > 0 AND created_at >= @since`
+	},
+}
+
+// Метрики пользователя без привязки к историям: только общая таблица
+var leaderboardUserScores = map[string]func(period string) string{
+	// Текущая серия; за неделю/месяц — среди тех, кто был активен в периоде
+	"streak": func(string) string {
+		return `SELECT user_id, streak_count::float AS score
+			FROM profiles WHERE streak_count > 0 AND last_active_at >= @since`
+	},
+	"xp": func(period string) string {
+		if period == "all" {
+			return `SELECT user_id, xp::float AS score FROM profiles WHERE xp > 0`
+		}
+		return `SELECT user_id, SUM(amount)::float AS score
+			FROM xp_events WHERE created_at >= @since GROUP BY user_id`
+	},
+}
+
+func leaderboardMetricExists(metric string) bool {
+	_, story := leaderboardStoryActivity[metric]
+	_, user := leaderboardUserScores[metric]
+	return story || user
+}
+
+// RefreshLeaderboards пересчитывает все таблицы лидеров
+func RefreshLeaderboards(db *gorm.DB) {
+	for period := range leaderboardPeriods {
+		for metric := range leaderboardStoryActivity {
+			if err := refreshLeaderboard(db, metric, period); err != nil {
+				log.Printf("Leaderboard refresh error (%s/%s): %v", metric, period, err)
+			}
+		}
+		for metric := range leaderboardUserScores {
+			if err := refreshLeaderboard(db, metric, period); err != nil {
+				log.Printf("Leaderboard refresh error (%s/%s): %v", metric, period, err)
+			}
+		}
+	}
+}
+
+func refreshLeaderboard(db *gorm.DB, metric, period string) error {
+	var scores string
+	if activity, ok := leaderboardStoryActivity[metric]; ok {
+		source := activity(period)
+		scores = `
+			SELECT 0 AS hashtag_id, activity.user_id, SUM(activity.weight) AS score
+			FROM (` + source + `) AS activity
+			GROUP BY activity.user_id
+			UNION ALL
+			SELECT story_hashtags.hashtag_id, activity.user_id, SUM(activity.weight)
+			FROM (` + source + `) AS activity
+			JOIN story_hashtags ON story_hashtags.story_id = activity.story_id
+			JOIN hashtags ON hashtags.id = story_hashtags.hashtag_id AND hashtags.is_banned = FALSE
+			GROUP BY story_hashtags.hashtag_id, activity.user_id`
+	} else {
+		scores = `SELECT 0 AS hashtag_id, user_scores.user_id, user_scores.score
+			FROM (` + leaderboardUserScores[metric](period) + `) AS user_scores`
+	}
+
+	params := map[string]interface{}{
+		"metric": metric,
+		"period": period,
+	}
+	since := leaderboardPeriods[period]
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("metric = ? AND period = ?", metric, period).Delete(&models.LeaderboardEntry{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			INSERT INTO leaderboard_entries (metric, period, hashtag_id, user_id, rank, score, computed_at)
+			SELECT @metric, @period, scores.hashtag_id, scores.user_id,
+				RANK() OVER (PARTITION BY scores.hashtag_id ORDER BY scores.score DESC),
+				scores.score, NOW()
+			FROM (`+strings.ReplaceAll(scores, "@since", since)+`) AS scores
+			JOIN users ON users.id = scores.user_id
+			WHERE scores.score > 0
+		`, params).Error
+	})
+}
+
+// StartLeaderboardWorker периодически пересчитывает таблицы лидеров до отмены ctx
+func StartLeaderboardWorker(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		RefreshLeaderboards(db)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				RefreshLeaderboards(db)
+			}
+		}
+	}()
+}
+
+// GetLeaderboard — таблица лидеров: GET /leaderboards/:metric?period=week|month|all&hashtag=name.
+// Для авторизованного пользователя в "me" его место, даже если он не в топе.
+func GetLeaderboard(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	metric := c.Param("metric")
+	if !leaderboardMetricExists(metric) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown leaderboard metric"})
+		return
+	}
+
+	period := c.DefaultQuery("period", "week")
+	if _, ok := leaderboardPeriods[period]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be one of week, month, all"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit <= 0 || limit > leaderboardTopN {
+		limit = 50
+	}
+
+	var hashtag *models.Hashtag
+	if name := c.Query("hashtag"); name != "" {
+		if _, ok := leaderboardStoryActivity[metric]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This metric has no per-hashtag leaderboard"})
+			return
+		}
+		found, err := lookupHashtag(db, utils.NormalizeHashtag(name))
+		if err != nil || found.IsBanned {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hashtag not found"})
+			return
+		}
+		hashtag = &found
+	}
+
+	var hashtagID uint
+	if hashtag != nil {
+		hashtagID = hashtag.ID
+	}
+	scope := db.Where("metric = ? AND period = ? AND hashtag_id = ?", metric, period, hashtagID)
+
+	var entries []models.LeaderboardEntry
+	if err := scope.Session(&gorm.Session{}).
+		Preload("User").Preload("User.Profile").
+		Order("rank, user_id").
+		Limit(limit).
+		Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch leaderboard"})
+		return
+	}
+
+	var total int64
+	scope.Session(&gorm.Session{}).Model(&models.LeaderboardEntry{}).Count(&total)
+
+	response := gin.H{
+		"metric":  metric,
+		"period":  period,
+		"hashtag": hashtag,
+		"entries": entries,
+		"count":   len(entries),
+		"total":   total,
+	}
+	if len(entries) > 0 {
+		response["computed_at"] = entries[0].ComputedAt
+	}
+
+	if viewerID := c.GetUint("user_id"); viewerID != 0 {
+		var me models.LeaderboardEntry
+		if err := scope.Session(&gorm.Session{}).Where("user_id = ?", viewerID).First(&me).Error; err == nil {
+			response["me"] = me
+		} else {
+			response["me"] = nil // пока нет очков за период
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/push"
+	"go_stories_api/utils"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// mentionField — текстовое поле, в котором ищем @упоминания
+type mentionField struct {
+	Name string
+	Text string
+}
+
+// mentionTarget — куда привязаны упоминания: история или комментарий
+type mentionTarget struct {
+	StoryID   *uint
+	CommentID *uint
+}
+
+func (t mentionTarget) scope(tx *gorm.DB) *gorm.DB {
+	if t.CommentID != nil {
+		return tx.Where("comment_id = ?", *t.CommentID)
+	}
+	return tx.Where("story_id = ? AND comment_id IS NULL", *t.StoryID)
+}
+
+// syncMentions пересобирает упоминания для истории/комментария.
+// Возвращает ID пользователей, которые упомянуты впервые (их и уведомляем).
+func syncMentions(tx *gorm.DB, authorID uint, target mentionTarget, fields ...mentionField) ([]models.Mention, []uint, error) {
+	var previous []models.Mention
+	if err := target.scope(tx).Find(&previous).Error; err != nil {
+		return nil, nil, err
+	}
+	alreadyMentioned := make(map[uint]bool)
+	for _, m := range previous {
+		alreadyMentioned[m.UserID] = true
+	}
+
+	if err := target.scope(tx).Delete(&models.Mention{}).Error; err != nil {
+		return nil, nil, err
+	}
+
+	tokensByField := make(map[string][]utils.MentionToken)
+	var allTokens []utils.MentionToken
+	for _, f := range fields {
+		tokens := utils.ExtractMentions(f.Text)
+		tokensByField[f.Name] = tokens
+		allTokens = append(allTokens, tokens...)
+	}
+
+	mentions := make([]models.Mention, 0)
+	names := utils.MentionedUsernames(allTokens)
+	if len(names) == 0 {
+		return mentions, nil, nil
+	}
+
+	var users []models.User
+	if err := tx.Where("LOWER(username) IN ?", names).Find(&users).Error; err != nil {
+		return nil, nil, err
+	}
+	usersByName := make(map[string]models.User)
+	for _, u := range users {
+		usersByName[strings.ToLower(u.Username)] = u
+	}
+
+	var newlyMentioned []uint
+	notified := make(map[uint]bool)
+	for _, f := range fields {
+		for _, token := range tokensByField[f.Name] {
+			user, ok := usersByName[strings.ToLower(token.Username)]
+			if !ok {
+				continue
+			}
+			mentions = append(mentions, models.Mention{
+				UserID:    user.ID,
+				AuthorID:  authorID,
+				StoryID:   target.StoryID,
+				CommentID: target.CommentID,
+				Field:     f.Name,
+				Offset:    token.Offset,
+				Length:    token.Length,
+				Username:  user.Username,
+			})
+			if !alreadyMentioned[user.ID] && !notified[user.ID] && user.ID != authorID {
+				notified[user.ID] = true
+				newlyMentioned = append(newlyMentioned, user.ID)
+			}
+		}
+	}
+
+	if len(mentions) > 0 {
+		if err := tx.Create(&mentions).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return mentions, newlyMentioned, nil
+}
+
+// notifyMentions создаёт уведомления об упоминании (блокировки учитывает notify.Send)
+// и отправляет push упомянутым
+func notifyMentions(db *gorm.DB, authorID uint, userIDs []uint, target mentionTarget) {
+	if len(userIDs) == 0 {
+		return
+	}
+
+	pushData := map[string]string{"type": notify.TypeMention}
+	for _, userID := range userIDs {
+		event := notify.Event{
+			UserID:  userID,
+			ActorID: authorID,
+			Type:    notify.TypeMention,
+			Payload: map[string]interface{}{},
+		}
+		if target.StoryID != nil {
+			event.TargetType = notify.TargetStory
+			event.TargetID = *target.StoryID
+			pushData["story_id"] = strconv.Itoa(int(*target.StoryID))
+		}
+		if target.CommentID != nil {
+			event.TargetType = notify.TargetComment
+			event.TargetID = *target.CommentID
+			var comment models.Comment
+			if err := db.Select("story_id").First(&comment, *target.CommentID).Error; err == nil {
+				event.Payload["story_id"] = comment.StoryID
+				pushData["story_id"] = strconv.Itoa(int(comment.StoryID))
+			}
+			pushData["comment_id"] = strconv.Itoa(int(*target.CommentID))
+		}
+		notify.Send(db, event)
+	}
+
+	var author models.User
+	if err := db.Select("id", "username").First(&author, authorID).Error; err != nil {
+		return
+	}
+	push.SendToUsers(db, notify.TypeMention, userIDs, push.Message{
+		Title: "New mention",
+		Body:  "@" + author.Username + " mentioned you",
+		Data:  pushData,
+	})
+}
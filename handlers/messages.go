@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"errors"
+	"go_stories_api/blocks"
+	"go_stories_api/models"
+	"go_stories_api/push"
+	"go_stories_api/wsservice"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы переписки
+const (
+	conversationActive   = "active"
+	conversationRequest  = "request" // первое сообщение от того, на кого получатель не подписан
+	conversationDeclined = "declined"
+)
+
+const maxMessageLength = 2000
+
+var (
+	errConversationNotFound = errors.New("Conversation not found")
+	errMessagingBlocked     = errors.New("Cannot message this user")
+	errRequestDeclined      = errors.New("Message request was declined")
+	errInvalidMessage       = errors.New("Message must be between 1 and 2000 characters")
+)
+
+// dmErrorStatus подбирает HTTP-статус для ошибок личных сообщений
+func dmErrorStatus(err error) int {
+	switch err {
+	case errConversationNotFound:
+		return http.StatusNotFound
+	case errMessagingBlocked, errRequestDeclined:
+		return http.StatusForbidden
+	case errInvalidMessage:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func otherParticipant(conv models.Conversation, userID uint) uint {
+	if conv.UserAID == userID {
+		return conv.UserBID
+	}
+	return conv.UserAID
+}
+
+// loadConversation загружает переписку, если пользователь в ней участвует
+func loadConversation(db *gorm.DB, conversationID, userID uint) (models.Conversation, error) {
+	var conv models.Conversation
+	err := db.Where("id = ? AND (user_a_id = ? OR user_b_id = ?)", conversationID, userID, userID).First(&conv).Error
+	if err == gorm.ErrRecordNotFound {
+		return conv, errConversationNotFound
+	}
+	return conv, err
+}
+
+// findOrCreateConversation возвращает переписку пары. Новая переписка с тем,
+// кто не подписан на отправителя, начинается как запрос.
+func findOrCreateConversation(db *gorm.DB, senderID, recipientID uint) (models.Conversation, error) {
+	var conv models.Conversation
+	if senderID == recipientID {
+		return conv, errMessagingBlocked
+	}
+
+	var recipient models.User
+	if err := db.Select("id").First(&recipient, recipientID).Error; err != nil {
+		return conv, errConversationNotFound
+	}
+	if blocks.Between(db, senderID, recipientID) {
+		return conv, errMessagingBlocked
+	}
+
+	a, b := senderID, recipientID
+	if a > b {
+		a, b = b, a
+	}
+	if err := db.Where("user_a_id = ? AND user_b_id = ?", a, b).First(&conv).Error; err == nil {
+		return conv, nil
+	}
+
+	conv = models.Conversation{UserAID: a, UserBID: b, Status: conversationActive}
+
+	var follows int64
+	db.Model(&models.Subscription{}).
+		Where("follower_id = ? AND following_id = ?", recipientID, senderID).
+		Count(&follows)
+	if follows == 0 {
+		conv.Status = conversationRequest
+		conv.RequestedBy = &senderID
+	}
+
+	// Параллельный запрос мог создать переписку раньше нас
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&conv).Error; err != nil {
+		return conv, err
+	}
+	if conv.ID == 0 {
+		if err := db.Where("user_a_id = ? AND user_b_id = ?", a, b).First(&conv).Error; err != nil {
+			return conv, err
+		}
+	}
+	return conv, nil
+}
+
+// sendDirectMessage сохраняет сообщение и доставляет его участникам по WebSocket.
+// Ответ получателя на запрос принимает его.
+func sendDirectMessage(db *gorm.DB, senderID uint, conv *models.Conversation, content string) (*models.Message, error) {
+	content = strings.TrimSpace(content)
+	if content == "" || utf8.RuneCountInString(content) > maxMessageLength {
+		return nil, errInvalidMessage
+	}
+
+	recipientID := otherParticipant(*conv, senderID)
+	if blocks.Between(db, senderID, recipientID) {
+		return nil, errMessagingBlocked
+	}
+
+	isRequester := conv.RequestedBy != nil && *conv.RequestedBy == senderID
+	if conv.Status == conversationDeclined && isRequester {
+		return nil, errRequestDeclined
+	}
+	accepted := conv.Status != conversationActive && !isRequester
+
+	message := models.Message{
+		ConversationID: conv.ID,
+		SenderID:       senderID,
+		Content:        content,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{"last_message_at": message.CreatedAt}
+		if accepted {
+			updates["status"] = conversationActive
+		}
+		return tx.Model(conv).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db.Preload("Sender").Preload("Sender.Profile").First(&message, message.ID)
+
+	event := gin.H{"conversation_id": conv.ID, "message": message}
+	wsservice.SendToUser(recipientID, wsservice.EventMessageNew, event)
+	wsservice.SendToUser(senderID, wsservice.EventMessageNew, event) // другие устройства отправителя
+
+	if accepted {
+		publishConversationUpdated(*conv)
+	}
+
+	// Запросы от незнакомых не должны будить получателя
+	if conv.Status == conversationActive {
+		go push.SendToUsers(db, "message", []uint{recipientID}, push.Message{
+			Title: "@" + message.Sender.Username,
+			Body:  content,
+			Data: map[string]string{
+				"type":            "message",
+				"conversation_id": strconv.Itoa(int(conv.ID)),
+			},
+		})
+	}
+
+	return &message, nil
+}
+
+func publishConversationUpdated(conv models.Conversation) {
+	event := gin.H{"conversation_id": conv.ID, "status": conv.Status}
+	wsservice.SendToUser(conv.UserAID, wsservice.EventConversationUpdated, event)
+	wsservice.SendToUser(conv.UserBID, wsservice.EventConversationUpdated, event)
+}
+
+// markMessages отмечает входящие сообщения до upToID (0 — все) доставленными или прочитанными
+// и отправляет отправителю квитанцию
+func markMessages(db *gorm.DB, userID uint, conv models.Conversation, upToID uint, read bool) (int64, error) {
+	now := time.Now()
+	column, eventType := "delivered_at", wsservice.EventMessageDelivered
+	if read {
+		column, eventType = "read_at", wsservice.EventMessageRead
+	}
+
+	query := db.Model(&models.Message{}).
+		Where("conversation_id = ? AND sender_id <> ? AND "+column+" IS NULL", conv.ID, userID)
+	if upToID != 0 {
+		query = query.Where("id <= ?", upToID)
+	}
+
+	updates := map[string]interface{}{column: now}
+	if read {
+		// прочитанное заодно и доставлено
+		updates["delivered_at"] = gorm.Expr("COALESCE(delivered_at, ?)", now)
+	}
+
+	result := query.Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return 0, result.Error
+	}
+
+	if upToID == 0 {
+		db.Model(&models.Message{}).Where("conversation_id = ?", conv.ID).Select("COALESCE(MAX(id), 0)").Scan(&upToID)
+	}
+	wsservice.SendToUser(otherParticipant(conv, userID), eventType, gin.H{
+		"conversation_id": conv.ID,
+		"up_to_id":        upToID,
+		"by_user_id":      userID,
+		column:            now,
+	})
+	return result.RowsAffected, nil
+}
+
+func conversationUnreadCount(db *gorm.DB, conversationID, userID uint) int64 {
+	var count int64
+	db.Model(&models.Message{}).
+		Where("conversation_id = ? AND sender_id <> ? AND read_at IS NULL", conversationID, userID).
+		Count(&count)
+	return count
+}
+
+// GetConversations — список переписок. ?folder=requests — входящие запросы от незнакомых.
+func GetConversations(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Model(&models.Conversation{}).
+		Where("(user_a_id = ? OR user_b_id = ?)", userID, userID).
+		Where("(CASE WHEN user_a_id = ? THEN user_b_id ELSE user_a_id END) NOT IN (?)", userID, blocks.UserIDs(db, userID))
+
+	folder := c.DefaultQuery("folder", "inbox")
+	switch folder {
+	case "inbox":
+		query = query.Where("status = ? OR requested_by = ?", conversationActive, userID)
+	case "requests":
+		query = query.Where("status = ? AND requested_by <> ?", conversationRequest, userID)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder"})
+		return
+	}
+
+	var conversations []models.Conversation
+	if err := query.
+		Preload("UserA").Preload("UserA.Profile").
+		Preload("UserB").Preload("UserB.Profile").
+		Order("last_message_at DESC NULLS LAST, id DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&conversations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch conversations"})
+		return
+	}
+
+	for i := range conversations {
+		var last models.Message
+		if err := db.Where("conversation_id = ?", conversations[i].ID).Order("id DESC").First(&last).Error; err == nil {
+			conversations[i].LastMessage = &last
+		}
+		conversations[i].UnreadCount = conversationUnreadCount(db, conversations[i].ID, userID)
+	}
+
+	var requestsCount int64
+	db.Model(&models.Conversation{}).
+		Where("(user_a_id = ? OR user_b_id = ?) AND status = ? AND requested_by <> ?", userID, userID, conversationRequest, userID).
+		Count(&requestsCount)
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations":  conversations,
+		"count":          len(conversations),
+		"page":           page,
+		"folder":         folder,
+		"requests_count": requestsCount,
+	})
+}
+
+// CreateConversation открывает переписку с пользователем и, если передан content, отправляет первое сообщение
+func CreateConversation(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	var req struct {
+		UserID  uint   `json:"user_id" binding:"required"`
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv, err := findOrCreateConversation(db, userID, req.UserID)
+	if err != nil {
+		c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"conversation": conv}
+	if strings.TrimSpace(req.Content) != "" {
+		message, err := sendDirectMessage(db, userID, &conv, req.Content)
+		if err != nil {
+			c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		response["message"] = message
+	}
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetConversationMessages — история сообщений от новых к старым, ?before_id= для следующей страницы.
+// Полученные входящие сообщения отмечаются доставленными.
+func GetConversationMessages(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	conv, err := loadConversation(db, uint(conversationID), userID)
+	if err != nil {
+		c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+
+	query := db.Where("conversation_id = ?", conv.ID)
+	if beforeID, err := strconv.Atoi(c.Query("before_id")); err == nil && beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var messages []models.Message
+	if err := query.Order("id DESC").Limit(limit).Find(&messages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+
+	if len(messages) > 0 {
+		go markMessages(db, userID, conv, messages[0].ID, false)
+	}
+
+	var nextBeforeID *uint
+	if len(messages) == limit {
+		nextBeforeID = &messages[len(messages)-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversation":   conv,
+		"messages":       messages,
+		"count":          len(messages),
+		"next_before_id": nextBeforeID,
+	})
+}
+
+func SendMessage(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req struct {
+		Content string `json:"content" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv, err := loadConversation(db, uint(conversationID), userID)
+	if err != nil {
+		c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := sendDirectMessage(db, userID, &conv, req.Content)
+	if err != nil {
+		c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, message)
+}
+
+// MarkConversationRead отмечает входящие сообщения прочитанными (до message_id или все)
+func MarkConversationRead(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req struct {
+		MessageID uint `json:"message_id"`
+	}
+	c.ShouldBindJSON(&req)
+
+	conv, err := loadConversation(db, uint(conversationID), userID)
+	if err != nil {
+		c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := markMessages(db, userID, conv, req.MessageID, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark messages as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Messages marked as read", "updated": updated})
+}
+
+// AcceptConversation принимает запрос на переписку (только получатель запроса)
+func AcceptConversation(c *gin.Context) {
+	respondToConversationRequest(c, conversationActive)
+}
+
+// DeclineConversation отклоняет запрос: отправитель больше не сможет писать в эту переписку
+func DeclineConversation(c *gin.Context) {
+	respondToConversationRequest(c, conversationDeclined)
+}
+
+func respondToConversationRequest(c *gin.Context, status string) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	conv, err := loadConversation(db, uint(conversationID), userID)
+	if err != nil {
+		c.JSON(dmErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	if conv.Status != conversationRequest || conv.RequestedBy == nil || *conv.RequestedBy == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No pending request in this conversation"})
+		return
+	}
+
+	if err := db.Model(&conv).Update("status", status).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update conversation"})
+		return
+	}
+
+	publishConversationUpdated(conv)
+	c.JSON(http.StatusOK, gin.H{"conversation": conv})
+}
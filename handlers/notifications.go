@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"go_stories_api/mail"
+	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/prefs"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func GetNotifications(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	query := db.Preload("Actor").Preload("Actor.Profile").Where("user_id = ?", userID)
+	if c.Query("unread_only") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []models.Notification
+	if err := query.Order("updated_at DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&notifications).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"notifications": notifications,
+		"count":         len(notifications),
+		"page":          page,
+		"unread_count":  notify.UnreadCount(db, userID),
+	})
+}
+
+func GetUnreadNotificationsCount(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	c.JSON(http.StatusOK, gin.H{"unread_count": notify.UnreadCount(db, userID)})
+}
+
+func MarkNotificationRead(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	notificationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	var notification models.Notification
+	if err := db.Where("id = ? AND user_id = ?", notificationID, userID).First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	if notification.ReadAt == nil {
+		if err := db.Model(&notification).Update("read_at", time.Now()).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification as read"})
+			return
+		}
+	}
+
+	go notify.PushUnreadCount(db, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Notification marked as read",
+		"unread_count": notify.UnreadCount(db, userID),
+	})
+}
+
+func MarkAllNotificationsRead(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	result := db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notifications as read"})
+		return
+	}
+
+	go notify.PushUnreadCount(db, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "All notifications marked as read",
+		"updated": result.RowsAffected,
+	})
+}
+
+type quietHoursRequest struct {
+	Enabled *bool   `json:"enabled"`
+	Start   *string `json:"start"`
+	End     *string `json:"end"`
+}
+
+type notificationPreferencesRequest struct {
+	Preferences map[string]map[string]bool `json:"preferences"`
+	QuietHours  *quietHoursRequest         `json:"quiet_hours"`
+	TimeZone    *string                    `json:"time_zone"`
+	Language    *string                    `json:"language"`
+}
+
+func notificationPreferencesResponse(db *gorm.DB, userID uint) (gin.H, error) {
+	settings, err := prefs.Settings(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile models.Profile
+	db.Select("language").Where("user_id = ?", userID).First(&profile)
+	language := mail.NormalizeLanguage(profile.Language)
+
+	return gin.H{
+		"preferences": prefs.Matrix(db, userID),
+		"quiet_hours": gin.H{
+			"enabled": settings.QuietHoursEnabled,
+			"start":   settings.QuietHoursStart,
+			"end":     settings.QuietHoursEnd,
+		},
+		"time_zone": prefs.UserLocation(db, userID).String(),
+		"language":  language,
+	}, nil
+}
+
+func GetNotificationPreferences(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	response, err := notificationPreferencesResponse(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// UpdateNotificationPreferences частично обновляет настройки: передаются только изменяемые поля
+func UpdateNotificationPreferences(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	var req notificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for event, channels := range req.Preferences {
+		for channel := range channels {
+			if !prefs.Valid(event, channel) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown preference: " + event + "/" + channel})
+				return
+			}
+		}
+	}
+
+	if req.TimeZone != nil {
+		if _, err := time.LoadLocation(*req.TimeZone); err != nil || *req.TimeZone == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time zone"})
+			return
+		}
+	}
+
+	if req.Language != nil && mail.NormalizeLanguage(*req.Language) != *req.Language {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported language"})
+		return
+	}
+
+	settings, err := prefs.Settings(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch preferences"})
+		return
+	}
+
+	if q := req.QuietHours; q != nil {
+		if q.Enabled != nil {
+			settings.QuietHoursEnabled = *q.Enabled
+		}
+		if q.Start != nil {
+			if _, err := prefs.ParseClock(*q.Start); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			settings.QuietHoursStart = *q.Start
+		}
+		if q.End != nil {
+			if _, err := prefs.ParseClock(*q.End); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			settings.QuietHoursEnd = *q.End
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for event, channels := range req.Preferences {
+			for channel, enabled := range channels {
+				if err := prefs.Set(tx, userID, event, channel, enabled); err != nil {
+					return err
+				}
+			}
+		}
+
+		if req.QuietHours != nil {
+			if err := tx.Model(&settings).
+				Select("quiet_hours_enabled", "quiet_hours_start", "quiet_hours_end").
+				Updates(&settings).Error; err != nil {
+				return err
+			}
+		}
+
+		if req.TimeZone != nil {
+			if err := tx.Model(&models.Profile{}).Where("user_id = ?", userID).Update("time_zone", *req.TimeZone).Error; err != nil {
+				return err
+			}
+		}
+
+		if req.Language != nil {
+			return tx.Model(&models.Profile{}).Where("user_id = ?", userID).Update("language", *req.Language).Error
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	response, err := notificationPreferencesResponse(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Страница подтверждения отписки: почтовые сканеры и предзагрузка ссылок ходят по GET,
+// поэтому сама отписка выполняется только формой (POST)
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Ravell — отписка</title></head>
+<body>
+{{if .Done}}<p>Вы отписались от писем.</p>{{else}}<form method="post" action="{{.Action}}">
+<input type="hidden" name="confirm" value="1">
+<p>{{if .Event}}Больше не присылать письма о событии «{{.Event}}»?{{else}}Больше не присылать письма Ravell?{{end}}</p>
+<button type="submit">Отписаться</button>
+</form>{{end}}
+</body>
+</html>`))
+
+func renderUnsubscribePage(c *gin.Context, data gin.H) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	unsubscribePage.Execute(c.Writer, data)
+}
+
+// UnsubscribeEmailPage — ссылка из письма (GET): проверяет токен и показывает подтверждение
+func UnsubscribeEmailPage(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	event := c.Query("type")
+	if event != "" && !prefs.Valid(event, prefs.ChannelEmail) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type"})
+		return
+	}
+
+	var settings models.NotificationSettings
+	if err := db.Where("unsubscribe_token = ?", token).First(&settings).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invalid unsubscribe link"})
+		return
+	}
+
+	renderUnsubscribePage(c, gin.H{"Action": c.Request.URL.RequestURI(), "Event": event})
+}
+
+// UnsubscribeEmail — отписка без авторизации: one-click из почтового клиента (RFC 8058)
+// или подтверждение со страницы. ?type=follow отключает письма об одном событии, без type — все письма.
+func UnsubscribeEmail(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	event := c.Query("type")
+	if event != "" && !prefs.Valid(event, prefs.ChannelEmail) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown notification type"})
+		return
+	}
+
+	if err := prefs.Unsubscribe(db, token, event); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid unsubscribe link"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsubscribe"})
+		return
+	}
+
+	if c.PostForm("confirm") != "" {
+		renderUnsubscribePage(c, gin.H{"Done": true})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "You have been unsubscribed"})
+}
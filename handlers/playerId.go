@@ -26,7 +26,9 @@ func SavePlayerID(c *gin.Context) {
         PlayerID: req.PlayerID,
     }
 
-    if err := db.Create(&device).Error; err != nil {
+    // Одно устройство — одна запись: повторная регистрация ничего не дублирует
+    if err := db.Where(models.UserDevice{UserID: userID, PlayerID: req.PlayerID}).
+        FirstOrCreate(&device).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save playerId"})
         return
     }
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"go_stories_api/blocks"
+	"go_stories_api/models"
+	"go_stories_api/wsservice"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Строки присутствия, которые не обновлялись дольше presenceTTL, считаются устаревшими
+// (экземпляр упал, не успев их удалить)
+const presenceTTL = 2 * time.Minute
+
+// trackPresence записывает, сколько соединений пользователя открыто на этом экземпляре.
+// Когда соединений не осталось, запоминает время последнего визита.
+func trackPresence(db *gorm.DB, userID uint) {
+	connections := wsservice.LocalUsers()[userID]
+	instanceID := wsservice.InstanceID()
+
+	if connections > 0 {
+		db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "instance_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"connections", "updated_at"}),
+		}).Create(&models.UserPresence{
+			UserID:      userID,
+			InstanceID:  instanceID,
+			Connections: connections,
+			UpdatedAt:   time.Now(),
+		})
+		return
+	}
+
+	db.Where("user_id = ? AND instance_id = ?", userID, instanceID).Delete(&models.UserPresence{})
+	db.Model(&models.Profile{}).Where("user_id = ?", userID).Update("last_seen_at", time.Now())
+}
+
+// trackReading обновляет, читает ли пользователь историю (подписан на её канал), и рассылает счётчик
+func trackReading(db *gorm.DB, userID, storyID uint) {
+	instanceID := wsservice.InstanceID()
+
+	if wsservice.IsSubscribed(userID, wsservice.StoryChannel(storyID)) {
+		db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "story_id"}, {Name: "user_id"}, {Name: "instance_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"updated_at"}),
+		}).Create(&models.StoryReader{
+			StoryID:    storyID,
+			UserID:     userID,
+			InstanceID: instanceID,
+			UpdatedAt:  time.Now(),
+		})
+	} else {
+		db.Where("story_id = ? AND user_id = ? AND instance_id = ?", storyID, userID, instanceID).
+			Delete(&models.StoryReader{})
+	}
+
+	publishStoryReaders(db, storyID)
+}
+
+// readersCount — сколько разных пользователей сейчас читают историю (на всех экземплярах)
+func readersCount(db *gorm.DB, storyID uint) int64 {
+	var count int64
+	db.Model(&models.StoryReader{}).
+		Where("story_id = ? AND updated_at > ?", storyID, time.Now().Add(-presenceTTL)).
+		Distinct("user_id").
+		Count(&count)
+	return count
+}
+
+func publishStoryReaders(db *gorm.DB, storyID uint) {
+	wsservice.Publish(wsservice.StoryChannel(storyID), wsservice.EventStoryReaders, gin.H{
+		"story_id": storyID,
+		"readers":  readersCount(db, storyID),
+	})
+}
+
+// trackChannel вызывается после подписки/отписки от канала
+func trackChannel(db *gorm.DB, userID uint, channel string) {
+	kind, id, err := wsservice.ParseChannel(channel)
+	if err == nil && kind == wsservice.ChannelStory {
+		trackReading(db, userID, id)
+	}
+}
+
+// StartPresenceWorker продлевает строки присутствия и читателей этого экземпляра
+// и удаляет устаревшие строки упавших экземпляров
+func StartPresenceWorker(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshPresence(db)
+			}
+		}
+	}()
+}
+
+func refreshPresence(db *gorm.DB) {
+	instanceID := wsservice.InstanceID()
+	now := time.Now()
+
+	for userID, connections := range wsservice.LocalUsers() {
+		db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "instance_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"connections", "updated_at"}),
+		}).Create(&models.UserPresence{
+			UserID:      userID,
+			InstanceID:  instanceID,
+			Connections: connections,
+			UpdatedAt:   now,
+		})
+	}
+
+	for channel, userIDs := range wsservice.LocalChannelUsers() {
+		kind, storyID, err := wsservice.ParseChannel(channel)
+		if err != nil || kind != wsservice.ChannelStory {
+			continue
+		}
+		db.Model(&models.StoryReader{}).
+			Where("story_id = ? AND instance_id = ? AND user_id IN ?", storyID, instanceID, userIDs).
+			Update("updated_at", now)
+	}
+
+	// Последний визит для тех, кто пропал вместе с упавшим экземпляром
+	var stale []models.UserPresence
+	db.Where("updated_at < ?", now.Add(-presenceTTL)).Find(&stale)
+	for _, p := range stale {
+		db.Model(&models.Profile{}).
+			Where("user_id = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", p.UserID, p.UpdatedAt).
+			Update("last_seen_at", p.UpdatedAt)
+	}
+	if len(stale) > 0 {
+		db.Where("updated_at < ?", now.Add(-presenceTTL)).Delete(&models.UserPresence{})
+	}
+
+	var staleStories []uint
+	db.Model(&models.StoryReader{}).Where("updated_at < ?", now.Add(-presenceTTL)).Distinct().Pluck("story_id", &staleStories)
+	if len(staleStories) > 0 {
+		if err := db.Where("updated_at < ?", now.Add(-presenceTTL)).Delete(&models.StoryReader{}).Error; err != nil {
+			log.Printf("Presence: failed to clean up readers: %v", err)
+			return
+		}
+		for _, storyID := range staleStories {
+			publishStoryReaders(db, storyID)
+		}
+	}
+}
+
+// ClearPresence убирает строки этого экземпляра — вызывается при остановке сервера
+func ClearPresence(db *gorm.DB) {
+	instanceID := wsservice.InstanceID()
+
+	var userIDs []uint
+	db.Model(&models.UserPresence{}).Where("instance_id = ?", instanceID).Pluck("user_id", &userIDs)
+	if len(userIDs) > 0 {
+		db.Model(&models.Profile{}).Where("user_id IN ?", userIDs).Update("last_seen_at", time.Now())
+	}
+
+	db.Where("instance_id = ?", instanceID).Delete(&models.UserPresence{})
+	db.Where("instance_id = ?", instanceID).Delete(&models.StoryReader{})
+}
+
+func isOnline(db *gorm.DB, userID uint) bool {
+	var count int64
+	db.Model(&models.UserPresence{}).
+		Where("user_id = ? AND connections > 0 AND updated_at > ?", userID, time.Now().Add(-presenceTTL)).
+		Count(&count)
+	return count > 0
+}
+
+// GetUserPresence — в сети ли пользователь и когда был последний раз.
+// Если пользователь скрыл присутствие, статус видит только он сам.
+func GetUserPresence(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var profile models.Profile
+	if err := db.Where("user_id = ?", userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	viewerID := c.GetUint("user_id")
+	if viewerID != 0 && blocks.Between(db, viewerID, uint(userID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if !profile.ShowPresence && viewerID != uint(userID) {
+		c.JSON(http.StatusOK, gin.H{
+			"user_id": userID,
+			"visible": false,
+		})
+		return
+	}
+
+	online := isOnline(db, uint(userID))
+	response := gin.H{
+		"user_id":      userID,
+		"visible":      true,
+		"online":       online,
+		"last_seen_at": profile.LastSeenAt,
+	}
+	if online {
+		response["last_seen_at"] = time.Now()
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"fmt"
+	"go_stories_api/achievements"
 	"go_stories_api/models"
 	"io"
 	"net/http"
@@ -31,26 +32,14 @@ func GetMyProfile(c *gin.Context) {
 		return
 	}
 
-	var achievement models.Achievement
-	if err := db.Where("key = ?", "early_access").First(&achievement).Error; err == nil {
-		var ua models.UserAchievement
-		if err := db.Where("user_id = ? AND achievement_id = ?", user.ID, achievement.ID).First(&ua).Error; err != nil {
-			ua = models.UserAchievement{
-				UserID:        user.ID,
-				AchievementID: achievement.ID,
-				Progress:      1.0,
-				Unlocked:      true,
-			}
-			db.Create(&ua)
-		}
-	}
-
 	var stats struct {
 		StoriesCount   int64 `json:"stories_count"`
 		FollowersCount int64 `json:"followers_count"`
 		FollowingCount int64 `json:"following_count"`
 	}
 
+	attachBadges(db, &user)
+
 	db.Model(&models.Story{}).Where("user_id = ?", user.ID).Count(&stats.StoriesCount)
 	db.Model(&models.Subscription{}).Where("following_id = ?", user.ID).Count(&stats.FollowersCount)
 	db.Model(&models.Subscription{}).Where("follower_id = ?", user.ID).Count(&stats.FollowingCount)
@@ -59,10 +48,12 @@ func GetMyProfile(c *gin.Context) {
 	isEarly := user.Profile.IsEarly || user.CreatedAt.Before(earlyCutoff)
 
 	c.JSON(http.StatusOK, gin.H{
-		"user":     user,
-		"profile":  user.Profile,
-		"stats":    stats,
-		"is_early": isEarly,
+		"user":              user,
+		"profile":           user.Profile,
+		"stats":             stats,
+		"level":             achievements.LevelFor(user.Profile.XP),
+		"is_early":          isEarly,
+		"followed_hashtags": getFollowedHashtags(db, user.ID),
 	})
 }
 
@@ -76,9 +67,10 @@ func UpdateProfile(c *gin.Context) {
 	}
 
 	var req struct {
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Bio       string `json:"bio"`
+		FirstName    string `json:"first_name"`
+		LastName     string `json:"last_name"`
+		Bio          string `json:"bio"`
+		ShowPresence *bool  `json:"show_presence"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -91,9 +83,13 @@ func UpdateProfile(c *gin.Context) {
 		"last_name":  req.LastName,
 	})
 
-	db.Model(&models.Profile{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+	profileUpdates := map[string]interface{}{
 		"bio": req.Bio,
-	})
+	}
+	if req.ShowPresence != nil {
+		profileUpdates["show_presence"] = *req.ShowPresence
+	}
+	db.Model(&models.Profile{}).Where("user_id = ?", userID).Updates(profileUpdates)
 
 	var user models.User
 	db.Preload("Profile").First(&user, userID)
@@ -254,6 +250,8 @@ func GetUserProfile(c *gin.Context) {
 		FollowingCount int64 `json:"following_count"`
 	}
 
+	attachBadges(db, &user)
+
 	db.Model(&models.Story{}).Where("user_id = ?", user.ID).Count(&stats.StoriesCount)
 	db.Model(&models.Subscription{}).Where("following_id = ?", user.ID).Count(&stats.FollowersCount)
 	db.Model(&models.Subscription{}).Where("follower_id = ?", user.ID).Count(&stats.FollowingCount)
@@ -273,11 +271,13 @@ func GetUserProfile(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"user":         user,
-		"profile":      user.Profile,
-		"stats":        stats,
-		"stories":      stories,
-		"is_following": isFollowing,
-		"is_early":     isEarly,
+		"user":              user,
+		"profile":           user.Profile,
+		"stats":             stats,
+		"level":             achievements.LevelFor(user.Profile.XP),
+		"stories":           stories,
+		"is_following":      isFollowing,
+		"is_early":          isEarly,
+		"followed_hashtags": getFollowedHashtags(db, user.ID),
 	})
 }
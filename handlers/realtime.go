@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"go_stories_api/models"
+	"go_stories_api/wsservice"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// publishStoryCounters рассылает подписчикам канала истории актуальные счётчики
+func publishStoryCounters(db *gorm.DB, storyID uint) {
+	var story models.Story
+	if err := db.Select("id", "reply_count", "views", "shares").First(&story, storyID).Error; err != nil {
+		return
+	}
+
+	var likesCount, commentsCount int64
+	db.Model(&models.Like{}).Where("story_id = ?", storyID).Count(&likesCount)
+	db.Model(&models.Comment{}).Where("story_id = ? AND is_deleted = ?", storyID, false).Count(&commentsCount)
+
+	wsservice.Publish(wsservice.StoryChannel(storyID), wsservice.EventStoryCounters, gin.H{
+		"story_id":       story.ID,
+		"likes_count":    likesCount,
+		"comments_count": commentsCount,
+		"reply_count":    story.ReplyCount,
+		"views":          story.Views,
+		"shares":         story.Shares,
+	})
+}
+
+// publishNewStory сообщает о новой истории в канал родителя (если это ответ) и в каналы её хэштегов.
+// story должна быть загружена с User и Hashtags.
+func publishNewStory(db *gorm.DB, story models.Story) {
+	if story.ReplyTo != nil {
+		wsservice.Publish(wsservice.StoryChannel(*story.ReplyTo), wsservice.EventStoryReply, gin.H{
+			"parent_id": *story.ReplyTo,
+			"story":     story,
+		})
+		publishStoryCounters(db, *story.ReplyTo)
+	}
+
+	for _, link := range story.Hashtags {
+		wsservice.Publish(wsservice.HashtagChannel(link.HashtagID), wsservice.EventHashtagStory, gin.H{
+			"hashtag_id": link.HashtagID,
+			"story":      story,
+		})
+	}
+}
+
+// publishNewComment сообщает подписчикам истории о новом комментарии
+func publishNewComment(db *gorm.DB, comment models.Comment) {
+	wsservice.Publish(wsservice.StoryChannel(comment.StoryID), wsservice.EventCommentCreated, gin.H{
+		"story_id": comment.StoryID,
+		"comment":  comment,
+	})
+	publishStoryCounters(db, comment.StoryID)
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"fmt"
+	"go_stories_api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Сколько ачивок можно выставить на витрину профиля
+const maxShowcaseBadges = 3
+
+// loadBadges возвращает витрины пользователей одним запросом: user_id -> ачивки по порядку
+func loadBadges(db *gorm.DB, userIDs []uint) map[uint][]models.Badge {
+	badges := make(map[uint][]models.Badge)
+	if len(userIDs) == 0 {
+		return badges
+	}
+
+	var rows []models.UserAchievement
+	db.Preload("Achievement").
+		Where("user_id IN ? AND unlocked = ? AND showcase > 0", userIDs, true).
+		Order("user_id, showcase").
+		Find(&rows)
+
+	for _, ua := range rows {
+		badges[ua.UserID] = append(badges[ua.UserID], models.Badge{
+			AchievementID: ua.AchievementID,
+			Key:           ua.Achievement.Key,
+			Title:         ua.Achievement.Title,
+			Icon:          ua.Achievement.Icon,
+			Tier:          ua.Tier,
+			Position:      ua.Showcase,
+			UnlockedAt:    ua.UnlockedAt,
+		})
+	}
+	return badges
+}
+
+// attachBadges заполняет витрины у загруженных пользователей
+func attachBadges(db *gorm.DB, users ...*models.User) {
+	seen := map[uint]bool{}
+	var ids []uint
+	for _, u := range users {
+		if u.ID != 0 && !seen[u.ID] {
+			seen[u.ID] = true
+			ids = append(ids, u.ID)
+		}
+	}
+
+	badges := loadBadges(db, ids)
+	for _, u := range users {
+		u.Badges = badges[u.ID]
+	}
+}
+
+// attachStoryBadges заполняет витрины авторов историй
+func attachStoryBadges(db *gorm.DB, stories []models.Story) {
+	users := make([]*models.User, len(stories))
+	for i := range stories {
+		users[i] = &stories[i].User
+	}
+	attachBadges(db, users...)
+}
+
+// GetShowcase — витрина текущего пользователя
+func GetShowcase(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	badges := loadBadges(db, []uint{userID})[userID]
+	if badges == nil {
+		badges = []models.Badge{}
+	}
+	c.JSON(http.StatusOK, gin.H{"badges": badges, "max": maxShowcaseBadges})
+}
+
+// UpdateShowcase выставляет на витрину полученные ачивки в заданном порядке:
+// {"achievement_ids": [3, 1]}. Пустой список очищает витрину.
+func UpdateShowcase(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	var input struct {
+		AchievementIDs []uint `json:"achievement_ids"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(input.AchievementIDs) > maxShowcaseBadges {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d achievements can be showcased", maxShowcaseBadges)})
+		return
+	}
+
+	seen := map[uint]bool{}
+	for _, id := range input.AchievementIDs {
+		if seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate achievement in showcase"})
+			return
+		}
+		seen[id] = true
+	}
+
+	if len(input.AchievementIDs) > 0 {
+		var unlocked int64
+		db.Model(&models.UserAchievement{}).
+			Where("user_id = ? AND achievement_id IN ? AND unlocked = ?", userID, input.AchievementIDs, true).
+			Count(&unlocked)
+		if int(unlocked) != len(input.AchievementIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only unlocked achievements can be showcased"})
+			return
+		}
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAchievement{}).
+			Where("user_id = ? AND showcase > 0", userID).
+			Update("showcase", 0).Error; err != nil {
+			return err
+		}
+		for i, id := range input.AchievementIDs {
+			if err := tx.Model(&models.UserAchievement{}).
+				Where("user_id = ? AND achievement_id = ?", userID, id).
+				Update("showcase", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update showcase"})
+		return
+	}
+
+	badges := loadBadges(db, []uint{userID})[userID]
+	if badges == nil {
+		badges = []models.Badge{}
+	}
+	c.JSON(http.StatusOK, gin.H{"badges": badges, "max": maxShowcaseBadges})
+}
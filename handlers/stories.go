@@ -2,7 +2,10 @@ package handlers
 
 import (
 	"fmt"
+	"go_stories_api/events"
 	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/push"
 	"log"
 	"net/http"
 	"strconv"
@@ -75,15 +78,26 @@ func GetStories(c *gin.Context) {
 			query = query.Joins("LEFT JOIN subscriptions ON subscriptions.following_id = stories.user_id AND subscriptions.follower_id = ?", userID)
 			rankingQuery += ` * (CASE WHEN subscriptions.follower_id IS NOT NULL THEN 1.5 ELSE 1.0 END)`
 
+			// Истории с хештегами, на которые подписан пользователь (буст 1.3)
+			followedTags := db.Table("story_hashtags").
+				Select("DISTINCT story_hashtags.story_id").
+				Joins("JOIN hashtag_follows ON hashtag_follows.hashtag_id = story_hashtags.hashtag_id").
+				Where("hashtag_follows.user_id = ?", userID)
+			query = query.Joins("LEFT JOIN (?) AS followed_tags ON followed_tags.story_id = stories.id", followedTags)
+			rankingQuery += ` * (CASE WHEN followed_tags.story_id IS NOT NULL THEN 1.3 ELSE 1.0 END)`
+
 			// Исключаем посты из "Не интересно"
 			query = query.Where("stories.id NOT IN (?)", db.Table("not_interesteds").Select("story_id").Where("user_id = ?", userID))
+
+			// Корневые истории + ответы с отслеживаемыми хештегами
+			query = query.Where("stories.reply_to IS NULL OR followed_tags.story_id IS NOT NULL")
+		} else {
+			// Показываем только корневые истории (не ответы), чтобы не засорять ленту
+			query = query.Where("stories.reply_to IS NULL")
 		}
 
 		// Применяем сортировку по формуле
 		query = query.Order(gorm.Expr(rankingQuery + " DESC"))
-		
-		// Показываем только корневые истории (не ответы), чтобы не засорять ленту
-		query = query.Where("stories.reply_to IS NULL")
 	}
 
 	// Выполняем запрос с пагинацией
@@ -92,6 +106,7 @@ func GetStories(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stories"})
 		return
 	}
+	attachStoryBadges(db, stories)
 
 	c.JSON(http.StatusOK, gin.H{
 		"stories": stories,
@@ -138,10 +153,11 @@ func GetStory(c *gin.Context) {
 	}
 
 	var story models.Story
-	if err := db.Preload("User").Preload("User.Profile").First(&story, id).Error; err != nil {
+	if err := db.Preload("User").Preload("User.Profile").Preload("Mentions").First(&story, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Story not found"})
 		return
 	}
+	attachBadges(db, &story.User)
 
 	// ✅ БЕЗОПАСНОЕ ПОЛУЧЕНИЕ user_id (без паники)
 	if uID, exists := c.Get("user_id"); exists {
@@ -202,7 +218,7 @@ func CreateStory(c *gin.Context) {
 	// Привязка хештегов
 	for _, hashtagID := range req.Hashtags {
 		var hashtag models.Hashtag
-		if err := tx.First(&hashtag, hashtagID).Error; err != nil {
+		if err := tx.Where("is_banned = ?", false).First(&hashtag, hashtagID).Error; err != nil {
 			continue
 		}
 		if err := tx.Create(&models.StoryHashtag{
@@ -215,46 +231,70 @@ func CreateStory(c *gin.Context) {
 		}
 	}
 
+	// #теги из заголовка и текста
+	if err := syncStoryHashtags(tx, story.ID, story.Title, story.Content); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add hashtag"})
+		return
+	}
+
+	// @упоминания
+	mentionTo := mentionTarget{StoryID: &story.ID}
+	_, mentioned, err := syncMentions(tx, userID, mentionTo,
+		mentionField{Name: "title", Text: story.Title},
+		mentionField{Name: "content", Text: story.Content},
+	)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save mentions"})
+		return
+	}
+
 	tx.Commit()
 
+	go notifyMentions(db, userID, mentioned, mentionTo)
+
 	// Загружаем автора, чтобы использовать username в пушах
-	db.Preload("User").Preload("User.Profile").First(&story, story.ID)
+	db.Preload("User").Preload("User.Profile").Preload("Hashtags.Hashtag").Preload("Mentions").First(&story, story.ID)
 
 	// --- Пуш подписчикам автора ---
-	var subs []models.Subscription
-	db.Where("following_id = ?", userID).Find(&subs)
-
-	var playerIDs []string
-	for _, sub := range subs {
-		var devices []models.UserDevice
-		db.Where("user_id = ?", sub.FollowerID).Find(&devices)
-		for _, d := range devices {
-			if d.PlayerID != "" {
-				playerIDs = append(playerIDs, d.PlayerID)
-			}
-		}
-	}
+	var followerIDs []uint
+	db.Model(&models.Subscription{}).Where("following_id = ?", userID).Pluck("follower_id", &followerIDs)
 
-	if len(playerIDs) > 0 {
-		
-	}
+	go push.SendToUsers(db, "new_story", followerIDs, push.Message{
+		Title: "@" + story.User.Username,
+		Body:  story.Title,
+		Data:  map[string]string{"type": "new_story", "story_id": strconv.Itoa(int(story.ID))},
+	})
 
 	// --- Пуш автору родительской истории, если это ответ ---
 	if req.ReplyTo != nil {
 		var parent models.Story
 		if err := db.Preload("User").First(&parent, *req.ReplyTo).Error; err == nil {
-			var devices []models.UserDevice
-			db.Where("user_id = ?", parent.UserID).Find(&devices)
-			var replyPlayerIDs []string
-			for _, d := range devices {
-				if d.PlayerID != "" {
-					replyPlayerIDs = append(replyPlayerIDs, d.PlayerID)
-				}
-			}
-			if len(replyPlayerIDs) > 0 {
-				
+			if parent.UserID != userID {
+				go push.SendToUsers(db, notify.TypeReply, []uint{parent.UserID}, push.Message{
+					Title: "@" + story.User.Username + " replied to your story",
+					Body:  story.Title,
+					Data:  map[string]string{"type": "reply", "story_id": strconv.Itoa(int(story.ID))},
+				})
+
+				events.Publish(events.Event{
+					Type:     events.ReplyReceived,
+					UserID:   parent.UserID,
+					ActorID:  userID,
+					TargetID: story.ID,
+				})
 			}
 
+			go notify.Send(db, notify.Event{
+				UserID:     parent.UserID,
+				ActorID:    userID,
+				Type:       notify.TypeReply,
+				TargetType: notify.TargetStory,
+				TargetID:   parent.ID,
+				Payload:    map[string]interface{}{"story_id": story.ID},
+			})
+
 			// Обновляем родительскую историю
 			now := time.Now()
 			db.Model(&models.Story{}).Where("id = ?", parent.ID).Updates(map[string]interface{}{
@@ -264,6 +304,15 @@ func CreateStory(c *gin.Context) {
 		}
 	}
 
+	go publishNewStory(db, story)
+
+	if story.ReplyTo != nil {
+		trackStreak(db, userID, "reply", story.CreatedAt)
+	} else {
+		trackStreak(db, userID, "story", story.CreatedAt)
+	}
+	events.Publish(events.Event{Type: events.StoryCreated, UserID: userID, TargetID: story.ID})
+
 	c.JSON(http.StatusCreated, story)
 }
 
@@ -307,11 +356,41 @@ func UpdateStory(c *gin.Context) {
 		updates["content"] = req.Content
 	}
 
-	if err := db.Model(&story).Updates(updates).Error; err != nil {
+	mentionTo := mentionTarget{StoryID: &story.ID}
+	var mentioned []uint
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) == 0 {
+			return nil
+		}
+		if err := tx.Model(&story).Updates(updates).Error; err != nil {
+			return err
+		}
+		if req.Title != "" {
+			story.Title = req.Title
+		}
+		if req.Content != "" {
+			story.Content = req.Content
+		}
+		// Пересинхронизируем #теги и @упоминания под новый текст
+		if err := syncStoryHashtags(tx, story.ID, story.Title, story.Content); err != nil {
+			return err
+		}
+		var err error
+		_, mentioned, err = syncMentions(tx, userID, mentionTo,
+			mentionField{Name: "title", Text: story.Title},
+			mentionField{Name: "content", Text: story.Content},
+		)
+		return err
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update story"})
 		return
 	}
 
+	go notifyMentions(db, userID, mentioned, mentionTo)
+
+	db.Preload("Hashtags.Hashtag").Preload("Mentions").First(&story, story.ID)
+
 	c.JSON(http.StatusOK, story)
 }
 
@@ -361,7 +440,14 @@ func DeleteStory(c *gin.Context) {
 		return
 	}
 
-	// 2️⃣ Удаляем саму историю
+	// 2️⃣ Удаляем упоминания
+	if err := tx.Where("story_id = ?", story.ID).Delete(&models.Mention{}).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove story mentions"})
+		return
+	}
+
+	// 3️⃣ Удаляем саму историю
 	if err := tx.Delete(&story).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete story"})
@@ -370,6 +456,9 @@ func DeleteStory(c *gin.Context) {
 
 	tx.Commit()
 
+	// Опыт за историю и за ответ автору родительской истории списываем
+	revokeXP(db, fmt.Sprintf("story:%d", story.ID), fmt.Sprintf("reply:%d", story.ID))
+
 	c.JSON(http.StatusOK, gin.H{"message": "Story deleted successfully"})
 }
 
@@ -398,11 +487,28 @@ func LikeStory(c *gin.Context) {
 			UserID:  userID,
 			StoryID: uint(storyID),
 		})
+
+		go notify.Send(db, notify.Event{
+			UserID:     story.UserID,
+			ActorID:    userID,
+			Type:       notify.TypeLike,
+			TargetType: notify.TargetStory,
+			TargetID:   story.ID,
+		})
+
+		events.Publish(events.Event{
+			Type:     events.StoryLiked,
+			UserID:   story.UserID,
+			ActorID:  userID,
+			TargetID: story.ID,
+		})
 	}
 
 	var likesCount int64
 	db.Model(&models.Like{}).Where("story_id = ?", storyID).Count(&likesCount)
 
+	go publishStoryCounters(db, story.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"liked":       err != nil,
 		"message":     "Operation successful",
@@ -456,6 +562,7 @@ func GetUserStories(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user stories"})
 		return
 	}
+	attachStoryBadges(db, stories)
 
 	c.JSON(http.StatusOK, gin.H{
 		"stories": stories,
@@ -484,6 +591,7 @@ func GetReplies(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch replies"})
         return
     }
+    attachStoryBadges(db, replies)
     
     // Опционально: Проверка, что родительская история существует
     // Хотя запрос Where("reply_to = ?", parentID) вернет пустой список,
@@ -508,6 +616,7 @@ func GetSeeds(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch seeds"})
 		return
 	}
+	attachStoryBadges(db, stories)
 	
 	c.JSON(http.StatusOK, gin.H{"stories": stories})
 }
@@ -524,6 +633,7 @@ func GetBranches(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch branches"})
 		return
 	}
+	attachStoryBadges(db, stories)
 	
 	c.JSON(http.StatusOK, gin.H{"stories": stories})
 }
\ No newline at end of file
@@ -1,51 +1,330 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"go_stories_api/events"
 	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/push"
+	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func UpdateStreak(c *gin.Context) {
-    db := c.MustGet("db").(*gorm.DB)
-    userID := c.MustGet("user_id").(uint)
+const (
+	streakDayLayout = "2006-01-02"
+	// За каждые streakFreezeEvery дней серии даётся заморозка, копится не больше maxStreakFreezes
+	streakFreezeEvery = 7
+	maxStreakFreezes  = 2
+	streakRewardDays  = 7
+	maxStreakHistory  = 366
+)
+
+// Вехи серии и опыт за них
+var streakMilestones = []struct {
+	Days int
+	XP   int
+}{
+	{3, 10},
+	{7, 30},
+	{14, 50},
+	{30, 100},
+	{50, 150},
+	{100, 300},
+	{200, 500},
+	{365, 1000},
+}
+
+// Действия, которые продлевают серию (STREAK_ACTIONS): story, reply, comment
+var streakActions = struct {
+	sync.RWMutex
+	enabled map[string]bool
+}{enabled: map[string]bool{"story": true, "reply": true}}
+
+// SetStreakActions задаёт действия, которые продлевают серию
+func SetStreakActions(actions []string) {
+	enabled := map[string]bool{}
+	for _, action := range actions {
+		switch action {
+		case "story", "reply", "comment":
+			enabled[action] = true
+		default:
+			log.Printf("Streak: unknown action %q in STREAK_ACTIONS", action)
+		}
+	}
+
+	streakActions.Lock()
+	streakActions.enabled = enabled
+	streakActions.Unlock()
+}
+
+// streakResult — что изменилось после засчитанной активности
+type streakResult struct {
+	Profile      models.Profile
+	Advanced     bool // серия выросла (первая активность за день)
+	FreezesUsed  int
+	FreezeEarned bool
+	RewardGiven  bool
+	Milestone    *models.StreakMilestone // веха, достигнутая этой активностью
+}
+
+func profileLocation(profile models.Profile) *time.Location {
+	loc, err := time.LoadLocation(profile.TimeZone)
+	if err != nil || profile.TimeZone == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// daysBetween — сколько календарных дней от a до b (обе даты YYYY-MM-DD)
+func daysBetween(a, b string) int {
+	from, err1 := time.Parse(streakDayLayout, a)
+	to, err2 := time.Parse(streakDayLayout, b)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// lastStreakDay — последний засчитанный день. У серий, начатых до календарных дней,
+// его нет — берём день последней активности.
+func lastStreakDay(profile models.Profile, loc *time.Location) string {
+	if profile.StreakDay != "" {
+		return profile.StreakDay
+	}
+	if profile.StreakCount > 0 && !profile.LastActiveAt.IsZero() {
+		return profile.LastActiveAt.In(loc).Format(streakDayLayout)
+	}
+	return ""
+}
+
+// effectiveStreak — текущая серия без записи: 0, если пропущено больше дней, чем есть заморозок
+func effectiveStreak(profile models.Profile, now time.Time) int {
+	loc := profileLocation(profile)
+	last := lastStreakDay(profile, loc)
+	if last == "" || profile.StreakCount == 0 {
+		return 0
+	}
+
+	missed := daysBetween(last, now.In(loc).Format(streakDayLayout)) - 1
+	if missed <= profile.StreakFreezes {
+		return profile.StreakCount
+	}
+	return 0
+}
+
+// recordStreakActivity засчитывает активность пользователя за его календарный день.
+// Пропущенные дни закрываются заморозками, если их хватает, иначе серия начинается заново.
+func recordStreakActivity(db *gorm.DB, userID uint, now time.Time) (streakResult, error) {
+	var result streakResult
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		profile := &result.Profile
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			First(profile).Error; err != nil {
+			return err
+		}
+
+		loc := profileLocation(*profile)
+		today := now.In(loc).Format(streakDayLayout)
+
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "day"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"count": gorm.Expr("streak_activities.count + 1"), "frozen": false}),
+		}).Create(&models.StreakActivity{UserID: userID, Day: today, Count: 1}).Error; err != nil {
+			return err
+		}
+
+		last := lastStreakDay(*profile, loc)
+		gap := daysBetween(last, today)
+
+		switch {
+		case last == "" || profile.StreakCount == 0:
+			profile.StreakCount = 1
+			result.Advanced = true
+
+		case gap <= 0:
+			// уже засчитано сегодня (или пользователь сменил пояс и "вернулся" во вчера)
+
+		case gap == 1:
+			profile.StreakCount++
+			result.Advanced = true
+
+		case gap-1 <= profile.StreakFreezes:
+			// Пропущенные дни закрываются заморозками и попадают в историю
+			start, _ := time.Parse(streakDayLayout, last)
+			for i := 1; i < gap; i++ {
+				day := start.AddDate(0, 0, i).Format(streakDayLayout)
+				if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+					Create(&models.StreakActivity{UserID: userID, Day: day, Frozen: true}).Error; err != nil {
+					return err
+				}
+			}
+			result.FreezesUsed = gap - 1
+			profile.StreakFreezes -= gap - 1
+			profile.StreakCount++
+			result.Advanced = true
+
+		default:
+			profile.StreakCount = 1
+			result.Advanced = true
+		}
+
+		updates := map[string]interface{}{"last_active_at": now}
+		if result.Advanced {
+			if profile.StreakCount%streakFreezeEvery == 0 && profile.StreakFreezes < maxStreakFreezes {
+				profile.StreakFreezes++
+				result.FreezeEarned = true
+			}
+			if profile.StreakCount > profile.LongestStreak {
+				profile.LongestStreak = profile.StreakCount
+			}
+			if profile.StreakCount >= streakRewardDays && !profile.StreakRewarded {
+				profile.StreakRewarded = true
+				result.RewardGiven = true
+			}
+			for _, m := range streakMilestones {
+				if m.Days != profile.StreakCount {
+					continue
+				}
+				milestone := models.StreakMilestone{UserID: userID, Days: m.Days, XP: m.XP}
+				created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&milestone)
+				if created.Error != nil {
+					return created.Error
+				}
+				if created.RowsAffected > 0 {
+					result.Milestone = &milestone
+				}
+			}
+
+			updates["streak_count"] = profile.StreakCount
+			updates["streak_day"] = today
+			updates["longest_streak"] = profile.LongestStreak
+			updates["streak_freezes"] = profile.StreakFreezes
+			updates["streak_rewarded"] = profile.StreakRewarded
+		}
+		profile.LastActiveAt = now
+		return tx.Model(profile).Updates(updates).Error
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if result.Advanced {
+		events.Publish(events.Event{Type: events.StreakUpdated, UserID: userID})
+	}
+	if m := result.Milestone; m != nil {
+		awardXP(db, userID, m.XP, "streak", fmt.Sprintf("streak_milestone:%d", m.Days))
+		notify.Send(db, notify.Event{
+			UserID:     userID,
+			Type:       notify.TypeStreak,
+			TargetType: notify.TargetUser,
+			TargetID:   userID,
+			Payload:    map[string]interface{}{"days": m.Days, "xp": m.XP},
+		})
+		go push.SendToUsers(db, notify.TypeStreak, []uint{userID}, push.Message{
+			Title: "Streak milestone",
+			Body:  fmt.Sprintf("%d days in a row! +%d XP", m.Days, m.XP),
+			Data:  map[string]string{"type": notify.TypeStreak, "days": strconv.Itoa(m.Days)},
+		})
+	}
+	return result, nil
+}
 
-    var profile models.Profile
-    if err := db.Where("user_id = ?", userID).First(&profile).Error; err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Profile not found"})
-        return
-    }
+// trackStreak засчитывает публикацию в серию прямо в запросе, если действие включено.
+// Через шину событий нельзя: при переполнении очереди событие теряется вместе с днём серии.
+func trackStreak(db *gorm.DB, userID uint, action string, at time.Time) {
+	streakActions.RLock()
+	enabled := streakActions.enabled[action]
+	streakActions.RUnlock()
+	if !enabled {
+		return
+	}
+
+	if _, err := recordStreakActivity(db, userID, at); err != nil {
+		log.Printf("Streak: failed to record activity of user %d: %v", userID, err)
+	}
+}
+
+// nextStreakMilestone — ближайшая ещё не достигнутая текущей серией веха (nil — все пройдены)
+func nextStreakMilestone(streak int) gin.H {
+	for _, m := range streakMilestones {
+		if m.Days > streak {
+			return gin.H{"days": m.Days, "xp": m.XP}
+		}
+	}
+	return nil
+}
 
-    now := time.Now().UTC()
-    last := profile.LastActiveAt
+// StartStreakWorker периодически обнуляет прервавшиеся серии, чтобы счётчик
+// в профиле, таблицах лидеров и ачивках не оставался старым
+func StartStreakWorker(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 
-    oneDay := 24 * time.Hour
-    rewardGiven := false
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				expireStreaks(db, time.Now())
+			}
+		}
+	}()
+}
 
-    if last.IsZero() || now.Sub(last) >= oneDay {
-        profile.StreakCount += 1
-        profile.LastActiveAt = now
+func expireStreaks(db *gorm.DB, now time.Time) {
+	// Грубый фильтр: в любом поясе серия с днём не раньше позавчера по UTC ещё жива
+	cutoff := now.UTC().AddDate(0, 0, -2).Format(streakDayLayout)
 
-        if profile.StreakCount >= 7 && !profile.StreakRewarded {
-            profile.StreakRewarded = true
-            rewardGiven = true
-        }
-    }
+	var profiles []models.Profile
+	db.Select("id", "user_id", "time_zone", "streak_count", "streak_day", "streak_freezes", "last_active_at").
+		Where("streak_count > 0 AND (streak_day < ? OR streak_day = '' OR streak_day IS NULL)", cutoff).
+		FindInBatches(&profiles, 200, func(tx *gorm.DB, batch int) error {
+			for _, profile := range profiles {
+				if effectiveStreak(profile, now) > 0 {
+					continue
+				}
+				if err := db.Model(&models.Profile{}).Where("id = ?", profile.ID).Update("streak_count", 0).Error; err != nil {
+					log.Printf("Streak: failed to reset streak of user %d: %v", profile.UserID, err)
+				}
+			}
+			return nil
+		})
+}
 
-    if err := db.Save(&profile).Error; err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update streak"})
-        return
-    }
+func streakResponse(profile models.Profile, now time.Time) gin.H {
+	var lastActive *time.Time
+	if !profile.LastActiveAt.IsZero() {
+		lastActive = &profile.LastActiveAt
+	}
 
-    c.JSON(http.StatusOK, gin.H{
-        "streak_count": profile.StreakCount,
-        "last_active":  profile.LastActiveAt,
-        "rewarded":     rewardGiven,
-    })
+	streak := effectiveStreak(profile, now)
+	return gin.H{
+		"streak_count":   streak,
+		"next_milestone": nextStreakMilestone(streak),
+		"longest_streak": profile.LongestStreak,
+		"streak_freezes": profile.StreakFreezes,
+		"streak_day":     profile.StreakDay,
+		"time_zone":      profileLocation(profile).String(),
+		"last_active":    lastActive, // null вместо 0001 года
+		"rewarded":       profile.StreakRewarded,
+	}
+}
+
+// UpdateStreak оставлен для старых клиентов: серия теперь продлевается на сервере
+// при публикации, а этот вызов только возвращает её текущее состояние
+func UpdateStreak(c *gin.Context) {
+	GetStreak(c)
 }
 
 func GetUserStreak(c *gin.Context) {
@@ -63,35 +342,67 @@ func GetUserStreak(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"streak_count": profile.StreakCount,
-		"last_active":  profile.LastActiveAt,
-		"rewarded":     profile.StreakRewarded,
-	})
+	c.JSON(http.StatusOK, streakResponse(profile, time.Now()))
 }
 
-
 func GetStreak(c *gin.Context) {
-    db := c.MustGet("db").(*gorm.DB)
-    userID := c.MustGet("user_id").(uint)
-
-    var profile models.Profile
-    if err := db.Where("user_id = ?", userID).First(&profile).Error; err != nil {
-        c.JSON(http.StatusOK, gin.H{
-            "streak_count": 0,
-            "rewarded": false,
-        })
-        return
-    }
-
-    var lastActive *time.Time
-    if !profile.LastActiveAt.IsZero() {
-        lastActive = &profile.LastActiveAt
-    }
-
-    c.JSON(http.StatusOK, gin.H{
-        "streak_count": profile.StreakCount,
-        "last_active":  lastActive, // null вместо 0001 года
-        "rewarded":     profile.StreakRewarded,
-    })
-}
\ No newline at end of file
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	var profile models.Profile
+	if err := db.Where("user_id = ?", userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"streak_count": 0,
+			"rewarded":     false,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, streakResponse(profile, time.Now()))
+}
+
+// GetUserStreakHistory — активность по дням за последние ?days= дней (по умолчанию 90)
+// для календаря-тепловой карты. Дни без активности не возвращаются.
+func GetUserStreakHistory(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "90"))
+	if days <= 0 || days > maxStreakHistory {
+		days = 90
+	}
+
+	var profile models.Profile
+	if err := db.Where("user_id = ?", uint(userID)).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	today := now.In(profileLocation(profile))
+	from := today.AddDate(0, 0, -(days - 1)).Format(streakDayLayout)
+	to := today.Format(streakDayLayout)
+
+	var activity []models.StreakActivity
+	if err := db.Where("user_id = ? AND day >= ? AND day <= ?", uint(userID), from, to).
+		Order("day").
+		Find(&activity).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch streak history"})
+		return
+	}
+
+	var milestones []models.StreakMilestone
+	db.Where("user_id = ?", uint(userID)).Order("reached_at").Find(&milestones)
+
+	response := streakResponse(profile, now)
+	response["milestones"] = milestones
+	response["from"] = from
+	response["to"] = to
+	response["days"] = activity
+	c.JSON(http.StatusOK, response)
+}
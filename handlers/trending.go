@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"go_stories_api/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Окна трендов: ключ из query-параметра -> интервал Postgres
+var trendingWindows = map[string]string{
+	"1h":  "1 hour",
+	"24h": "24 hours",
+	"7d":  "7 days",
+}
+
+const trendingTopN = 100
+
+// Активность по историям за два последних окна.
+// Веса: просмотр 1, лайк 3, ответ 10. Окно задаётся параметром @window.
+// kind — вид активности, чтобы считать события по виду, а не угадывать по весу.
+const trendingActivitySQL = `
+	SELECT post_id AS story_id, created_at, 1.0 AS weight, 'view' AS kind
+	FROM post_views WHERE created_at >= NOW() - 2 * CAST(@window AS interval)
+	UNION ALL
+	SELECT story_id, created_at, 3.0 AS weight, 'like' AS kind
+	FROM likes WHERE created_at >= NOW() - 2 * CAST(@window AS interval)
+	UNION ALL
+	SELECT reply_to AS story_id, created_at, 10.0 AS weight, 'reply' AS kind
+	FROM stories WHERE reply_to IS NOT NULL AND created_at >= NOW() - 2 * CAST(@window AS interval)
+`
+
+// Скорость: активность текущего окна + половина прироста к предыдущему окну
+const trendingVelocitySQL = `
+	GREATEST(
+		SUM(CASE WHEN activity.created_at >= NOW() - CAST(@window AS interval) THEN activity.weight ELSE 0 END) * 1.5
+		- SUM(CASE WHEN activity.created_at < NOW() - CAST(@window AS interval) THEN activity.weight ELSE 0 END) * 0.5,
+		0
+	)
+`
+
+// RefreshTrending пересчитывает кэш трендов для всех окон
+func RefreshTrending(db *gorm.DB) {
+	for period, window := range trendingWindows {
+		if err := refreshTrendingWindow(db, period, window); err != nil {
+			log.Printf("Trending refresh error (%s): %v", period, err)
+		}
+	}
+}
+
+func refreshTrendingWindow(db *gorm.DB, period, window string) error {
+	params := map[string]interface{}{
+		"window": window,
+		"period": period,
+		"limit":  trendingTopN,
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("period = ?", period).Delete(&models.TrendingStory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("period = ?", period).Delete(&models.TrendingHashtag{}).Error; err != nil {
+			return err
+		}
+
+		// 1. Истории
+		err := tx.Exec(`
+			INSERT INTO trending_stories (story_id, period, score, computed_at)
+			SELECT activity.story_id, @period, `+trendingVelocitySQL+`, NOW()
+			FROM (`+trendingActivitySQL+`) AS activity
+			JOIN stories ON stories.id = activity.story_id
+			GROUP BY activity.story_id
+			HAVING `+trendingVelocitySQL+` > 0
+			ORDER BY 3 DESC
+			LIMIT @limit
+		`, params).Error
+		if err != nil {
+			return err
+		}
+
+		// 2. Хештеги: активность их историй + новые истории с тегом (вес 5)
+		return tx.Exec(`
+			INSERT INTO trending_hashtags (hashtag_id, period, score, stories_count, computed_at)
+			SELECT activity.hashtag_id, @period, `+trendingVelocitySQL+`,
+				COUNT(*) FILTER (WHERE activity.kind = 'story' AND activity.created_at >= NOW() - CAST(@window AS interval)),
+				NOW()
+			FROM (
+				SELECT story_hashtags.hashtag_id, story_activity.created_at, story_activity.weight, story_activity.kind
+				FROM (`+trendingActivitySQL+`) AS story_activity
+				JOIN story_hashtags ON story_hashtags.story_id = story_activity.story_id
+				UNION ALL
+				SELECT hashtag_id, created_at, 5.0 AS weight, 'story' AS kind
+				FROM story_hashtags WHERE created_at >= NOW() - 2 * CAST(@window AS interval)
+			) AS activity
+			JOIN hashtags ON hashtags.id = activity.hashtag_id AND hashtags.is_banned = FALSE
+			GROUP BY activity.hashtag_id
+			HAVING `+trendingVelocitySQL+` > 0
+			ORDER BY 3 DESC
+			LIMIT @limit
+		`, params).Error
+	})
+}
+
+// StartTrendingWorker периодически пересчитывает тренды до отмены ctx
+func StartTrendingWorker(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	go func() {
+		RefreshTrending(db)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				RefreshTrending(db)
+			}
+		}
+	}()
+}
+
+// trendingPeriod читает ?window=, по умолчанию 24h
+func trendingPeriod(c *gin.Context) (string, bool) {
+	period := c.DefaultQuery("window", "24h")
+	_, ok := trendingWindows[period]
+	return period, ok
+}
+
+func GetTrendingHashtags(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	period, ok := trendingPeriod(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be one of 1h, 24h, 7d"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if limit <= 0 || limit > trendingTopN {
+		limit = 20
+	}
+
+	var trending []models.TrendingHashtag
+	if err := db.Preload("Hashtag").
+		Where("period = ?", period).
+		Order("score DESC").
+		Limit(limit).
+		Find(&trending).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trending hashtags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hashtags": trending,
+		"count":    len(trending),
+		"window":   period,
+	})
+}
+
+func GetTrendingStories(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+
+	period, ok := trendingPeriod(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be one of 1h, 24h, 7d"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > trendingTopN {
+		limit = 10
+	}
+
+	var stories []models.Story
+	if err := db.Preload("User").Preload("User.Profile").
+		Joins("JOIN trending_stories ON trending_stories.story_id = stories.id").
+		Where("trending_stories.period = ?", period).
+		Order("trending_stories.score DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&stories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trending stories"})
+		return
+	}
+	attachStoryBadges(db, stories)
+
+	c.JSON(http.StatusOK, gin.H{
+		"stories": stories,
+		"count":   len(stories),
+		"page":    page,
+		"window":  period,
+	})
+}
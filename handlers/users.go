@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"go_stories_api/blocks"
+	"go_stories_api/events"
+	"go_stories_api/mail"
 	"go_stories_api/models"
+	"go_stories_api/notify"
+	"go_stories_api/push"
 	"net/http"
-	"log"
-	"net/smtp"
-	"os"
 	"strconv"
     "time"
 
@@ -44,6 +46,12 @@ func GetFollowers(c *gin.Context) {
 	var subscriptions []models.Subscription
 	db.Where("following_id = ?", userID).Find(&subscriptions)
 
+	followerIDs := make([]uint, len(subscriptions))
+	for i, sub := range subscriptions {
+		followerIDs[i] = sub.FollowerID
+	}
+	badges := loadBadges(db, followerIDs)
+
 	var result []gin.H
 	for _, sub := range subscriptions {
 		var follower models.User
@@ -66,6 +74,7 @@ func GetFollowers(c *gin.Context) {
 				"username": follower.Username,
 				"bio":      follower.Profile.Bio,
 				"avatar":   follower.Profile.Avatar,
+				"badges":   badges[follower.ID],
 			},
 			"is_following": isFollowing,
 			"followed_at":  sub.CreatedAt,
@@ -82,6 +91,12 @@ func GetFollowing(c *gin.Context) {
 	var subscriptions []models.Subscription
 	db.Where("follower_id = ?", userID).Find(&subscriptions)
 
+	followingIDs := make([]uint, len(subscriptions))
+	for i, sub := range subscriptions {
+		followingIDs[i] = sub.FollowingID
+	}
+	badges := loadBadges(db, followingIDs)
+
 	var result []gin.H
 	for _, sub := range subscriptions {
 		var followingUser models.User
@@ -103,6 +118,7 @@ func GetFollowing(c *gin.Context) {
 				"username": followingUser.Username,
 				"bio":      followingUser.Profile.Bio,
 				"avatar":      followingUser.Profile.Avatar,
+				"badges":   badges[followingUser.ID],
 			},
 			"is_following": isFollowing,
 			"followed_at":  sub.CreatedAt,
@@ -112,31 +128,6 @@ func GetFollowing(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"following": result})
 }
 
-func send(body string, to string) {
-    if to == "" {
-        log.Println("Email is empty, skipping sending")
-        return
-    }
-
-    from := "serrelvorne@gmail.com"
-    pass := os.Getenv("EMAIL_PASS") // Убедитесь, что здесь App Password
-
-    msg := "From: " + from + "\n" +
-        "To: " + to + "\n" +
-        "Subject: Notification from Ravell\n\n" +
-        body
-
-    err := smtp.SendMail("smtp.gmail.com:587",
-        smtp.PlainAuth("", from, pass, "smtp.gmail.com"),
-        from, []string{to}, []byte(msg))
-
-    if err != nil {
-        log.Printf("smtp error: %s", err)
-        return
-    }
-    log.Println("Successfully sent email to " + to)
-}
-
 func FollowUser(c *gin.Context) {
     db := c.MustGet("db").(*gorm.DB)
     followerID := c.MustGet("user_id").(uint)
@@ -168,6 +159,11 @@ func FollowUser(c *gin.Context) {
         return
     }
 
+    if blocks.Between(db, followerID, followeeID) {
+        c.JSON(http.StatusForbidden, gin.H{"error": "Cannot follow this user"})
+        return
+    }
+
     // Проверка существующей подписки
     var existingSub models.Subscription
     if err := db.Where("follower_id = ? AND following_id = ?", followerID, followeeID).
@@ -188,13 +184,24 @@ func FollowUser(c *gin.Context) {
     }
 
     // 3. Теперь переменные follower и followee существуют, можно отправлять
-    // Запускаем в горутине, чтобы клиент не ждал отправки письма
-    go send("User @"+follower.Username+" followed you!", followee.Email)
+    // Письмо ставится в outbox, отправит воркер
+    go mail.NotifyUser(db, followeeID, "follow", "follow", map[string]interface{}{"Username": follower.Username})
+
+    go notify.Send(db, notify.Event{
+        UserID:     followeeID,
+        ActorID:    followerID,
+        Type:       notify.TypeFollow,
+        TargetType: notify.TargetUser,
+        TargetID:   followeeID,
+    })
 
-    // Логика для Push уведомлений...
-    var devices []models.UserDevice
-    db.Where("user_id = ?", followeeID).Find(&devices)
-    // ... тут ваш код отправки пушей ...
+    go push.SendToUsers(db, notify.TypeFollow, []uint{followeeID}, push.Message{
+        Title: "New follower",
+        Body:  "@" + follower.Username + " followed you",
+        Data:  map[string]string{"type": "follow", "user_id": strconv.Itoa(int(followerID))},
+    })
+
+    events.Publish(events.Event{Type: events.UserFollowed, UserID: followeeID, ActorID: followerID})
 
     c.JSON(http.StatusOK, gin.H{"message": "Followed successfully"})
 }
@@ -248,6 +255,8 @@ func ActivateInfluencer(c *gin.Context) {
 		Where("user_id = ?", user.ID).
 		Update("is_early", true)
 
+	events.Publish(events.Event{Type: events.EarlyAccessGranted, UserID: user.ID})
+
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Influencer activated",
 		"user_id":  user.ID,
@@ -289,8 +298,8 @@ func UnfollowUser(c *gin.Context) {
         var targetUser models.User
         if err := db.First(&targetUser, targetUserID).Error; err == nil {
             
-            // 3. Отправляем письмо
-            go send("User @"+currentUser.Username+" unfollowed you.", targetUser.Email)
+            // 3. Ставим письмо в очередь
+            go mail.NotifyUser(db, targetUser.ID, "unfollow", "unfollow", map[string]interface{}{"Username": currentUser.Username})
         }
     }
     // ---------------------------------------------------------------------------
@@ -390,4 +399,68 @@ func AddInfluencer(c *gin.Context) {
         "message": "Influencer added successfully",
         "feature": feature,
     })
-}
\ No newline at end of file
+}
+
+func BlockUser(c *gin.Context) {
+    db := c.MustGet("db").(*gorm.DB)
+    blockerID := c.MustGet("user_id").(uint)
+
+    blockedID64, err := strconv.ParseUint(c.Param("id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+        return
+    }
+    blockedID := uint(blockedID64)
+
+    if blockerID == blockedID {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot block yourself"})
+        return
+    }
+
+    var blocked models.User
+    if err := db.First(&blocked, blockedID).Error; err != nil {
+        c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+        return
+    }
+
+    var existing models.UserBlock
+    if err := db.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).
+        First(&existing).Error; err == nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "User already blocked"})
+        return
+    }
+
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(&models.UserBlock{BlockerID: blockerID, BlockedID: blockedID}).Error; err != nil {
+            return err
+        }
+        // Блокировка разрывает подписки в обе стороны
+        return tx.Where("(follower_id = ? AND following_id = ?) OR (follower_id = ? AND following_id = ?)",
+            blockerID, blockedID, blockedID, blockerID).
+            Delete(&models.Subscription{}).Error
+    })
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to block user"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "User blocked"})
+}
+
+func UnblockUser(c *gin.Context) {
+    db := c.MustGet("db").(*gorm.DB)
+    blockerID := c.MustGet("user_id").(uint)
+
+    result := db.Where("blocker_id = ? AND blocked_id = ?", blockerID, c.Param("id")).Delete(&models.UserBlock{})
+    if result.Error != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unblock user"})
+        return
+    }
+
+    if result.RowsAffected == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "User is not blocked"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "User unblocked"})
+}
@@ -2,25 +2,27 @@ package handlers
 
 import (
 	"encoding/json"
+	"go_stories_api/blocks"
+	"go_stories_api/models"
+	"go_stories_api/notify"
 	"go_stories_api/wsservice"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"gorm.io/gorm"
 )
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-// структура для сообщений через WS
-type WSMessage struct {
-	Action  string `json:"action"`   // "send_to_user"
-	UserID  uint   `json:"user_id"`  // кому шлём
-	Message string `json:"message"`  // текст уведомления
-}
-
+// WSHandler — WebSocket с типизированным протоколом (см. wsservice/protocol.go).
+// Сервер присылает события в конвертах {v, type, id, ts, channel, reply_to, data},
+// клиент может подписываться на каналы историй и хэштегов; каждая команда подтверждается ack или error.
 func WSHandler(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
 	userID := c.GetUint("userID") // берём из JWT middleware
 
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -29,31 +31,150 @@ func WSHandler(c *gin.Context) {
 		return
 	}
 
-	wsservice.AddConnection(userID, conn)
-	defer func() {
-		wsservice.RemoveConnection(userID, conn)
+	client, err := wsservice.AddConnection(userID, conn)
+	if err != nil {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error()),
+			time.Now().Add(time.Second))
 		conn.Close()
+		return
+	}
+	defer func() {
+		channels := client.Channels()
+		wsservice.RemoveConnection(client)
+
+		trackPresence(db, userID)
+		for _, channel := range channels {
+			trackChannel(db, userID, channel)
+		}
 	}()
+	trackPresence(db, userID)
+
+	client.Send(wsservice.NewEnvelope(wsservice.EventWelcome, gin.H{
+		"protocol_version": wsservice.ProtocolVersion,
+		"user_id":          userID,
+		"unread_count":     notify.UnreadCount(db, userID),
+	}))
+
+	// Запись идёт через очередь соединения, здесь только чтение команд
+	client.ReadLoop(func(data []byte) {
+		var msg wsservice.ClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			client.Send(wsservice.Error("", "Invalid message format"))
+			return
+		}
+		if msg.V != 0 && msg.V != wsservice.ProtocolVersion {
+			client.Send(wsservice.Error(msg.ID, "Unsupported protocol version"))
+			return
+		}
+
+		handleWSCommand(db, client, msg)
+	})
+}
+
+func handleWSCommand(db *gorm.DB, client *wsservice.Client, msg wsservice.ClientMessage) {
+	switch msg.Type {
+	case wsservice.CommandPing:
+		pong := wsservice.NewEnvelope(wsservice.EventPong, nil)
+		pong.ReplyTo = msg.ID
+		client.Send(pong)
+
+	case wsservice.CommandSubscribe:
+		if errMsg := checkWSChannel(db, client.UserID, msg.Channel); errMsg != "" {
+			client.Send(wsservice.Error(msg.ID, errMsg))
+			return
+		}
+		if err := wsservice.Subscribe(client, msg.Channel); err != nil {
+			client.Send(wsservice.Error(msg.ID, err.Error()))
+			return
+		}
+		client.Send(wsservice.Ack(msg.ID, gin.H{"channel": msg.Channel}))
+		go trackChannel(db, client.UserID, msg.Channel)
+
+	case wsservice.CommandUnsubscribe:
+		wsservice.Unsubscribe(client, msg.Channel)
+		client.Send(wsservice.Ack(msg.ID, gin.H{"channel": msg.Channel}))
+		go trackChannel(db, client.UserID, msg.Channel)
+
+	case wsservice.CommandMessageSend:
+		var data struct {
+			ConversationID uint   `json:"conversation_id"`
+			UserID         uint   `json:"user_id"`
+			Content        string `json:"content"`
+		}
+		if err := json.Unmarshal(msg.Data, &data); err != nil {
+			client.Send(wsservice.Error(msg.ID, "Invalid message data"))
+			return
+		}
 
-	for {
-		_, msgBytes, err := conn.ReadMessage()
+		var conv models.Conversation
+		var err error
+		if data.ConversationID != 0 {
+			conv, err = loadConversation(db, data.ConversationID, client.UserID)
+		} else {
+			conv, err = findOrCreateConversation(db, client.UserID, data.UserID)
+		}
 		if err != nil {
-			break
+			client.Send(wsservice.Error(msg.ID, err.Error()))
+			return
 		}
 
-		// парсим сообщение
-		var msg WSMessage
-		if err := json.Unmarshal(msgBytes, &msg); err != nil {
-			continue // игнорируем кривые сообщения
+		message, err := sendDirectMessage(db, client.UserID, &conv, data.Content)
+		if err != nil {
+			client.Send(wsservice.Error(msg.ID, err.Error()))
+			return
 		}
+		client.Send(wsservice.Ack(msg.ID, gin.H{"conversation_id": conv.ID, "message": message}))
 
-		// если пришёл push другому пользователю
-		if msg.Action == "send_to_user" && msg.UserID != 0 && msg.Message != "" {
-			wsservice.SendNotification(msg.UserID, map[string]string{
-				"type":          "follow", // или другой тип
-				"from_username": "Система", 
-				"message":       msg.Message,
-			})
+	case wsservice.CommandMessageDelivered, wsservice.CommandMessageRead:
+		var data struct {
+			ConversationID uint `json:"conversation_id"`
+			MessageID      uint `json:"message_id"`
+		}
+		if err := json.Unmarshal(msg.Data, &data); err != nil {
+			client.Send(wsservice.Error(msg.ID, "Invalid message data"))
+			return
+		}
+
+		conv, err := loadConversation(db, data.ConversationID, client.UserID)
+		if err != nil {
+			client.Send(wsservice.Error(msg.ID, err.Error()))
+			return
+		}
+
+		updated, err := markMessages(db, client.UserID, conv, data.MessageID, msg.Type == wsservice.CommandMessageRead)
+		if err != nil {
+			client.Send(wsservice.Error(msg.ID, "Failed to update messages"))
+			return
+		}
+		client.Send(wsservice.Ack(msg.ID, gin.H{"conversation_id": conv.ID, "updated": updated}))
+
+	default:
+		client.Send(wsservice.Error(msg.ID, "Unknown command type"))
+	}
+}
+
+// checkWSChannel проверяет, что канал существует и доступен пользователю
+func checkWSChannel(db *gorm.DB, userID uint, channel string) string {
+	kind, id, err := wsservice.ParseChannel(channel)
+	if err != nil {
+		return err.Error()
+	}
+
+	switch kind {
+	case wsservice.ChannelStory:
+		var story models.Story
+		if err := db.Select("id", "user_id").First(&story, id).Error; err != nil {
+			return "Story not found"
+		}
+		if blocks.Between(db, userID, story.UserID) {
+			return "Story not found"
+		}
+	case wsservice.ChannelHashtag:
+		var hashtag models.Hashtag
+		if err := db.First(&hashtag, id).Error; err != nil || hashtag.IsBanned {
+			return "Hashtag not found"
 		}
 	}
+	return ""
 }
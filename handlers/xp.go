@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"fmt"
+	"go_stories_api/achievements"
+	"go_stories_api/events"
+	"go_stories_api/models"
+	"go_stories_api/notify"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Опыт за активность. Лайк и подписка засчитываются один раз на пару пользователей,
+// серия — один раз в день.
+var activityXP = map[string]int{
+	events.StoryCreated:   10,
+	events.ReplyReceived:  5,
+	events.StoryLiked:     1,
+	events.UserFollowed:   2,
+	events.CommentCreated: 2,
+	events.StreakUpdated:  5,
+}
+
+// activityXPKey — ключ идемпотентности начисления за событие
+func activityXPKey(ev events.Event) (reason, key string) {
+	switch ev.Type {
+	case events.StoryCreated:
+		return "story", fmt.Sprintf("story:%d", ev.TargetID)
+	case events.ReplyReceived:
+		return "reply", fmt.Sprintf("reply:%d", ev.TargetID)
+	case events.StoryLiked:
+		return "like", fmt.Sprintf("like:%d:%d", ev.TargetID, ev.ActorID)
+	case events.UserFollowed:
+		return "follow", fmt.Sprintf("follow:%d", ev.ActorID)
+	case events.CommentCreated:
+		return "comment", fmt.Sprintf("comment:%d", ev.TargetID)
+	case events.StreakUpdated:
+		return "streak", "streak:" + ev.At.UTC().Format("2006-01-02")
+	}
+	return "", ""
+}
+
+// SubscribeXP начисляет опыт за активность по доменным событиям
+func SubscribeXP(db *gorm.DB) {
+	types := make([]string, 0, len(activityXP))
+	for t := range activityXP {
+		types = append(types, t)
+	}
+
+	events.Subscribe(func(ev events.Event) {
+		if ev.ActorID != 0 && ev.ActorID == ev.UserID {
+			return // свои лайки и ответы самому себе опыта не дают
+		}
+		if ev.Type == events.StoryCreated || ev.Type == events.ReplyReceived {
+			// история могла быть удалена, пока событие ждало в очереди
+			if db.Select("id").First(&models.Story{}, ev.TargetID).Error != nil {
+				return
+			}
+		}
+		reason, key := activityXPKey(ev)
+		awardXP(db, ev.UserID, activityXP[ev.Type], reason, key)
+	}, types...)
+}
+
+// awardXP начисляет опыт один раз на key и пересчитывает уровень.
+// При повышении уровня пользователь получает уведомление.
+func awardXP(db *gorm.DB, userID uint, amount int, reason, key string) {
+	if amount <= 0 || userID == 0 {
+		return
+	}
+
+	var before, after achievements.LevelInfo
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.XPEvent{
+			UserID: userID,
+			Key:    key,
+			Reason: reason,
+			Amount: amount,
+		})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		var profile models.Profile
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", userID).
+			First(&profile).Error; err != nil {
+			return err
+		}
+
+		before = achievements.LevelFor(profile.XP)
+		after = achievements.LevelFor(profile.XP + int64(amount))
+		return tx.Model(&profile).Updates(map[string]interface{}{
+			"xp":    after.XP,
+			"level": after.Level,
+		}).Error
+	})
+	if err != nil {
+		log.Printf("XP: failed to award %d (%s) to user %d: %v", amount, key, userID, err)
+		return
+	}
+
+	if after.Level > before.Level {
+		notify.Send(db, notify.Event{
+			UserID:     userID,
+			Type:       notify.TypeLevelUp,
+			TargetType: notify.TargetUser,
+			TargetID:   userID,
+			Payload:    map[string]interface{}{"level": after.Level, "xp": after.XP},
+		})
+	}
+}
+
+// revokeXP отменяет начисления по ключам (например, за удалённую историю), чтобы опыт
+// нельзя было набирать созданием и удалением постов. В истории появляется обратная запись.
+func revokeXP(db *gorm.DB, keys ...string) {
+	var awarded []models.XPEvent
+	db.Where("key IN ? AND amount > 0", keys).Find(&awarded)
+
+	for _, ev := range awarded {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.XPEvent{
+				UserID: ev.UserID,
+				Key:    "revoke:" + ev.Key,
+				Reason: ev.Reason,
+				Amount: -ev.Amount,
+			})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			var profile models.Profile
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("user_id = ?", ev.UserID).
+				First(&profile).Error; err != nil {
+				return err
+			}
+
+			after := achievements.LevelFor(profile.XP - int64(ev.Amount))
+			return tx.Model(&profile).Updates(map[string]interface{}{
+				"xp":    after.XP,
+				"level": after.Level,
+			}).Error
+		})
+		if err != nil {
+			log.Printf("XP: failed to revoke %s from user %d: %v", ev.Key, ev.UserID, err)
+		}
+	}
+}
+
+// GetXPHistory — уровень и история начислений опыта текущего пользователя
+func GetXPHistory(c *gin.Context) {
+	db := c.MustGet("db").(*gorm.DB)
+	userID := c.MustGet("user_id").(uint)
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var profile models.Profile
+	if err := db.Where("user_id = ?", userID).First(&profile).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Profile not found"})
+		return
+	}
+
+	var history []models.XPEvent
+	if err := db.Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Offset((page - 1) * limit).
+		Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch XP history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"level":   achievements.LevelFor(profile.XP),
+		"history": history,
+		"count":   len(history),
+		"page":    page,
+	})
+}
@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go_stories_api/config"
+	"mime"
+	"mime/quotedprintable"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Message — готовое к отправке письмо
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Headers map[string]string // дополнительные заголовки, например List-Unsubscribe
+}
+
+// Mailer — способ доставки писем (SMTP, файлы, память)
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// PermanentError — письмо не будет доставлено и при повторе (5xx от SMTP, битый адрес)
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return "permanent: " + e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// NewMailerFromConfig создаёт почтовый драйвер по MAIL_DRIVER
+func NewMailerFromConfig(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "":
+		return nil, nil
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.SMTPUser == "" {
+			return nil, fmt.Errorf("SMTP_HOST and SMTP_USER are required")
+		}
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPass, cfg.FromEmail), nil
+	case "file":
+		return NewFileMailer(cfg.MailDir, cfg.FromEmail)
+	case "memory":
+		return NewMemoryMailer(), nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.MailDriver)
+}
+
+// build собирает MIME-письмо multipart/alternative (текст + HTML)
+func build(from string, msg Message) []byte {
+	var buf bytes.Buffer
+	boundary := newBoundary()
+
+	headers := map[string]string{
+		"From":         from,
+		"To":           msg.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+		"Content-Type": `multipart/alternative; boundary="` + boundary + `"`,
+	}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buf.WriteString(k + ": " + headerValue(headers[k]) + "\r\n")
+	}
+	buf.WriteString("\r\n")
+
+	writePart(&buf, boundary, "text/plain; charset=utf-8", msg.Text)
+	if msg.HTML != "" {
+		writePart(&buf, boundary, "text/html; charset=utf-8", msg.HTML)
+	}
+	buf.WriteString("--" + boundary + "--\r\n")
+
+	return buf.Bytes()
+}
+
+// headerValue убирает переводы строк, чтобы нельзя было подставить свои заголовки
+func headerValue(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+func writePart(buf *bytes.Buffer, boundary, contentType, body string) {
+	buf.WriteString("--" + boundary + "\r\n")
+	buf.WriteString("Content-Type: " + contentType + "\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(buf)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	buf.WriteString("\r\n")
+}
+
+func newBoundary() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
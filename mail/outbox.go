@@ -0,0 +1,260 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go_stories_api/models"
+	"go_stories_api/prefs"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Статусы письма в outbox
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+const (
+	maxAttempts  = 10
+	baseBackoff  = time.Minute
+	maxBackoff   = 6 * time.Hour
+	batchSize    = 50
+	sendLease    = 5 * time.Minute // пока письмо отправляется, другие воркеры его не берут
+	sendDeadline = time.Minute
+)
+
+// ErrDisabled — доставка писем выключена (нет драйвера), письмо не сохраняется
+var ErrDisabled = errors.New("mail: delivery is disabled")
+
+// Без драйвера outbox никто не разбирает, поэтому письма в него не ставятся
+var delivery = struct {
+	sync.RWMutex
+	enabled bool
+}{}
+
+// SetEnabled включает постановку писем в outbox; вызывается, когда есть драйвер и воркер
+func SetEnabled(enabled bool) {
+	delivery.Lock()
+	defer delivery.Unlock()
+	delivery.enabled = enabled
+}
+
+// Enabled — доставка писем включена
+func Enabled() bool {
+	delivery.RLock()
+	defer delivery.RUnlock()
+	return delivery.enabled
+}
+
+// Email — письмо по шаблону для постановки в очередь
+type Email struct {
+	UserID   uint // 0 — письмо не привязано к пользователю
+	To       string
+	Template string
+	Language string
+	Data     map[string]interface{}
+	Headers  map[string]string
+}
+
+// Enqueue рендерит письмо и сохраняет его в outbox. Отправит воркер.
+func Enqueue(db *gorm.DB, e Email) (*models.EmailOutbox, error) {
+	if !Enabled() {
+		return nil, ErrDisabled
+	}
+	if e.To == "" {
+		return nil, errors.New("mail: empty recipient")
+	}
+
+	msg, err := Render(e.Language, e.Template, e.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, _ := json.Marshal(e.Headers)
+	row := models.EmailOutbox{
+		To:            e.To,
+		Subject:       msg.Subject,
+		TextBody:      msg.Text,
+		HTMLBody:      msg.HTML,
+		Headers:       datatypes.JSON(headers),
+		Template:      e.Template,
+		Status:        StatusPending,
+		NextAttemptAt: time.Now(),
+	}
+	if e.UserID != 0 {
+		row.UserID = &e.UserID
+	}
+
+	if err := db.Create(&row).Error; err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+// NotifyUser ставит в очередь письмо пользователю о событии event:
+// учитывает настройки email-канала, язык пользователя и добавляет ссылку отписки.
+// При выключенной доставке ничего не делает.
+func NotifyUser(db *gorm.DB, userID uint, event, template string, data map[string]interface{}) error {
+	if !Enabled() {
+		return nil
+	}
+	if !prefs.Allowed(db, userID, event, prefs.ChannelEmail) {
+		return nil
+	}
+
+	var user models.User
+	if err := db.Preload("Profile").First(&user, userID).Error; err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return nil
+	}
+
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	headers := map[string]string{}
+	if unsubscribeURL := prefs.UnsubscribeURL(db, userID, event); unsubscribeURL != "" {
+		data["UnsubscribeURL"] = unsubscribeURL
+		// Отписка в один клик (RFC 8058)
+		headers["List-Unsubscribe"] = "<" + unsubscribeURL + ">"
+		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	}
+
+	_, err := Enqueue(db, Email{
+		UserID:   userID,
+		To:       user.Email,
+		Template: template,
+		Language: user.Profile.Language,
+		Data:     data,
+		Headers:  headers,
+	})
+	return err
+}
+
+// StartOutboxWorker периодически отправляет письма из outbox, пока ctx не отменён
+func StartOutboxWorker(ctx context.Context, db *gorm.DB, m Mailer, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			for {
+				sent, err := ProcessOutbox(ctx, db, m)
+				if err != nil {
+					log.Printf("mail: outbox error: %v", err)
+				}
+				// полная пачка — скорее всего, есть ещё
+				if err != nil || sent < batchSize || ctx.Err() != nil {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// ProcessOutbox отправляет одну пачку готовых писем, возвращает количество обработанных
+func ProcessOutbox(ctx context.Context, db *gorm.DB, m Mailer) (int, error) {
+	var batch []models.EmailOutbox
+
+	// Забираем пачку и продлеваем next_attempt_at, чтобы параллельные воркеры её пропустили.
+	// Если процесс упадёт во время отправки, письма вернутся в работу после истечения аренды.
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", StatusPending, time.Now()).
+			Order("next_attempt_at").
+			Limit(batchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(batch))
+		for i, row := range batch {
+			ids[i] = row.ID
+		}
+		return tx.Model(&models.EmailOutbox{}).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(sendLease)).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, row := range batch {
+		if ctx.Err() != nil {
+			break
+		}
+		deliver(ctx, db, m, row)
+	}
+	return len(batch), nil
+}
+
+func deliver(ctx context.Context, db *gorm.DB, m Mailer, row models.EmailOutbox) {
+	var headers map[string]string
+	if len(row.Headers) > 0 {
+		json.Unmarshal(row.Headers, &headers)
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, sendDeadline)
+	err := m.Send(sendCtx, Message{
+		To:      row.To,
+		Subject: row.Subject,
+		Text:    row.TextBody,
+		HTML:    row.HTMLBody,
+		Headers: headers,
+	})
+	cancel()
+
+	if err == nil {
+		now := time.Now()
+		db.Model(&row).Updates(map[string]interface{}{
+			"status":     StatusSent,
+			"sent_at":    &now,
+			"attempts":   row.Attempts + 1,
+			"last_error": "",
+		})
+		return
+	}
+
+	attempts := row.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":   attempts,
+		"last_error": err.Error(),
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) || attempts >= maxAttempts {
+		updates["status"] = StatusFailed
+		log.Printf("mail: giving up on email %d to %s: %v", row.ID, row.To, err)
+	} else {
+		updates["next_attempt_at"] = time.Now().Add(backoff(attempts))
+		log.Printf("mail: email %d to %s failed (attempt %d): %v", row.ID, row.To, attempts, err)
+	}
+	db.Model(&row).Updates(updates)
+}
+
+// backoff — экспоненциальная задержка: 1m, 2m, 4m, ... но не больше 6h
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff << (attempts - 1)
+	if delay > maxBackoff || delay <= 0 {
+		return maxBackoff
+	}
+	return delay
+}
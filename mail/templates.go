@@ -0,0 +1,84 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Поддерживаемые языки писем; первый — по умолчанию
+var Languages = []string{"ru", "en"}
+
+// NormalizeLanguage возвращает поддерживаемый язык ("en-US" -> "en"), иначе язык по умолчанию
+func NormalizeLanguage(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if i := strings.IndexAny(lang, "-_"); i > 0 {
+		lang = lang[:i]
+	}
+	for _, l := range Languages {
+		if l == lang {
+			return l
+		}
+	}
+	return Languages[0]
+}
+
+// Render рендерит шаблон name на языке lang.
+// templates/<lang>/<name>.txt определяет "subject" и "text", <name>.html — "content" для layout.
+func Render(lang, name string, data map[string]interface{}) (Message, error) {
+	lang = NormalizeLanguage(lang)
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["UnsubscribeURL"]; !ok {
+		data["UnsubscribeURL"] = ""
+	}
+
+	text, err := texttemplate.ParseFS(templateFS,
+		"templates/"+lang+"/layout.txt",
+		"templates/"+lang+"/"+name+".txt")
+	if err != nil {
+		return Message{}, fmt.Errorf("mail: template %s/%s: %v", lang, name, err)
+	}
+
+	var subject, body bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.ExecuteTemplate(&body, "text", data); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+	}
+
+	// HTML-версия необязательна
+	if _, err := fs.Stat(templateFS, "templates/"+lang+"/"+name+".html"); err != nil {
+		return msg, nil
+	}
+
+	html, err := htmltemplate.ParseFS(templateFS,
+		"templates/"+lang+"/layout.html",
+		"templates/"+lang+"/"+name+".html")
+	if err != nil {
+		return Message{}, fmt.Errorf("mail: template %s/%s.html: %v", lang, name, err)
+	}
+
+	data["Subject"] = msg.Subject
+	var htmlBody bytes.Buffer
+	if err := html.ExecuteTemplate(&htmlBody, "layout", data); err != nil {
+		return Message{}, err
+	}
+	msg.HTML = htmlBody.String()
+
+	return msg, nil
+}
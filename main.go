@@ -3,18 +3,27 @@ package main
 import (
 	"context"
 	"fmt"
+	"go_stories_api/config"
 	"go_stories_api/database"
+	"go_stories_api/events"
 	"go_stories_api/handlers"
+	"go_stories_api/mail"
 	"go_stories_api/middleware"
+	"go_stories_api/models"
+	"go_stories_api/prefs"
+	"go_stories_api/push"
+	"go_stories_api/wsservice"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // часовые пояса пользователей, даже если в образе нет tzdata
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"gorm.io/gorm"
 )
 
 func main() {
@@ -29,6 +38,57 @@ func main() {
 	db := database.InitDB()
 	database.MigrateDB(db)
 
+	cfg := config.LoadConfig()
+
+	// ================= PUSH =================
+	pushSender, err := push.NewSenderFromConfig(cfg)
+	if err != nil {
+		log.Printf("Push notifications disabled: %v", err)
+	}
+	push.SetSender(pushSender)
+	prefs.SetPublicURL(cfg.PublicURL)
+	handlers.SetStreakActions(cfg.StreakActions)
+
+	// ================= MAIL =================
+	mailer, err := mail.NewMailerFromConfig(cfg)
+	if err != nil {
+		log.Printf("Email delivery disabled: %v", err)
+	}
+	mail.SetEnabled(mailer != nil)
+
+	// go run . recompute-achievements [key] — пересчитать ачивки без запуска сервера.
+	// Запускается после настройки push, чтобы уведомления о полученных ачивках ушли и отсюда.
+	if len(os.Args) > 1 && os.Args[1] == "recompute-achievements" {
+		os.Exit(recomputeAchievementsCLI(db, os.Args[2:]))
+	}
+
+	// ================= WORKERS =================
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	wsBroker, err := wsservice.NewBroker(workersCtx, cfg.WSBroker, database.DSN())
+	if err != nil {
+		log.Printf("WebSocket broker %q unavailable, falling back to memory: %v", cfg.WSBroker, err)
+		wsBroker = wsservice.NewMemoryBroker()
+	}
+	wsservice.SetBroker(workersCtx, wsBroker)
+	defer wsBroker.Close()
+
+	// Доменные события: ачивки считаются асинхронно, вне запросов
+	handlers.SubscribeAchievements(db)
+	handlers.SubscribeXP(db)
+	events.Start(workersCtx, 4)
+
+	handlers.ResumeAchievementRecomputes(workersCtx, db)
+
+	handlers.StartTrendingWorker(workersCtx, db, 10*time.Minute)
+	handlers.StartLeaderboardWorker(workersCtx, db, 15*time.Minute)
+	handlers.StartStreakWorker(workersCtx, db, time.Hour)
+	handlers.StartPresenceWorker(workersCtx, db, 30*time.Second)
+	if mailer != nil {
+		mail.StartOutboxWorker(workersCtx, db, mailer, 30*time.Second)
+		handlers.StartDigestWorker(workersCtx, db, time.Hour)
+	}
 
 	defer func() {
 		sqlDB, _ := db.DB()
@@ -76,6 +136,9 @@ func main() {
 		profile.PUT("/profile", handlers.UpdateProfile)
 		profile.PUT("/profile/with-image", handlers.UpdateProfileWithImage)
 		profile.DELETE("/account", handlers.DeleteAccount)
+		profile.GET("/profile/xp", handlers.GetXPHistory)
+		profile.GET("/profile/showcase", handlers.GetShowcase)
+		profile.PUT("/profile/showcase", handlers.UpdateShowcase)
 	}
 
 	// ================= STORIES =================
@@ -84,9 +147,10 @@ func main() {
 		stories.GET("/", middleware.OptionalJWTAuth(), handlers.GetStories)
 
 		stories.GET("/seeds", handlers.GetSeeds)
+		stories.GET("/trending", handlers.GetTrendingStories)
 		stories.GET("/branches", handlers.GetBranches)
 		stories.GET("/:id", middleware.OptionalJWTAuth(), handlers.GetStory)
-		stories.GET("/:id/comments", handlers.GetComments)
+		stories.GET("/:id/comments", middleware.OptionalJWTAuth(), handlers.GetComments)
 		stories.GET("/:id/replies", handlers.GetReplies)
 
 		protected := stories.Group("/")
@@ -108,10 +172,9 @@ func main() {
 		comments.POST("/", handlers.CreateComment)
 		comments.PUT("/:id", handlers.UpdateComment)
 		comments.DELETE("/:id", handlers.DeleteComment)
+		comments.POST("/:id/like", handlers.LikeComment)
 	}
 
-	r.POST("/achievements/create", handlers.CreateAchievement)
-	r.DELETE("/hashtags/:id", handlers.DeleteHashtag)
 	r.POST("/stories/:id/share", handlers.ShareStory)
 
 
@@ -124,6 +187,9 @@ func main() {
 		users.GET("/:id/followers", handlers.GetFollowers)
 		users.GET("/:id/following", handlers.GetFollowing)
 		users.GET("/:id/streak", handlers.GetUserStreak)
+		users.GET("/:id/streak/history", handlers.GetUserStreakHistory)
+		users.GET("/:id/hashtags", handlers.GetFollowedHashtags)
+		users.GET("/:id/presence", middleware.OptionalJWTAuth(), handlers.GetUserPresence)
 		users.GET("/:id/achievements", middleware.JWTAuth(), handlers.GetUserAchievementsByID)
 		
 		
@@ -134,9 +200,11 @@ func main() {
 		{
 			protected.POST("/:id/follow", handlers.FollowUser)
 			protected.POST("/:id/unfollow", handlers.UnfollowUser)
+			protected.POST("/:id/block", handlers.BlockUser)
+			protected.POST("/:id/unblock", handlers.UnblockUser)
 			protected.POST("/save-player", handlers.SavePlayerID)
 			protected.POST("/influencers/add", handlers.AddInfluencer)
-			protected.POST("/achievements/grant_influential", handlers.GrantInfluencerAchievement)
+			protected.POST("/achievements/grant_influential", middleware.ModeratorOnly(), handlers.GrantInfluencerAchievement)
 		}
 		users.GET("/influencers/early", handlers.GetActiveInfluencers)
 		users.POST("/influencers/activate", middleware.JWTAuth(), handlers.ActivateInfluencer)
@@ -144,6 +212,35 @@ func main() {
 
 	}
 
+	// ================= NOTIFICATIONS =================
+	notifications := r.Group("/notifications")
+	notifications.Use(middleware.JWTAuth())
+	{
+		notifications.GET("", handlers.GetNotifications)
+		notifications.GET("/unread-count", handlers.GetUnreadNotificationsCount)
+		notifications.POST("/read-all", handlers.MarkAllNotificationsRead)
+		notifications.POST("/:id/read", handlers.MarkNotificationRead)
+		notifications.GET("/preferences", handlers.GetNotificationPreferences)
+		notifications.PUT("/preferences", handlers.UpdateNotificationPreferences)
+	}
+
+	// ================= MESSAGES =================
+	conversations := r.Group("/conversations")
+	conversations.Use(middleware.JWTAuth())
+	{
+		conversations.GET("", handlers.GetConversations)
+		conversations.POST("", handlers.CreateConversation)
+		conversations.GET("/:id/messages", handlers.GetConversationMessages)
+		conversations.POST("/:id/messages", handlers.SendMessage)
+		conversations.POST("/:id/read", handlers.MarkConversationRead)
+		conversations.POST("/:id/accept", handlers.AcceptConversation)
+		conversations.POST("/:id/decline", handlers.DeclineConversation)
+	}
+
+	// Отписка из письма: GET — страница подтверждения (ссылки открывают и сканеры), POST — отписка (форма или List-Unsubscribe-Post)
+	r.GET("/unsubscribe", handlers.UnsubscribeEmailPage)
+	r.POST("/unsubscribe", handlers.UnsubscribeEmail)
+
 	streak := r.Group("/streak")
 	streak.Use(middleware.JWTAuth())
 	{
@@ -155,13 +252,49 @@ func main() {
 	hashtags := r.Group("/hashtags")
 	{
 		hashtags.GET("/", handlers.GetHashtags)
+		hashtags.GET("/trending", handlers.GetTrendingHashtags)
 		hashtags.GET("/:id/stories", handlers.GetHashtagStories)
 
 		protected := hashtags.Group("/")
 		protected.Use(middleware.JWTAuth())
 		{
 			protected.POST("/", handlers.CreateHashtag)
+			protected.POST("/:id/follow", handlers.FollowHashtag)
+			protected.POST("/:id/unfollow", handlers.UnfollowHashtag)
 		}
+
+		moderation := hashtags.Group("/")
+		moderation.Use(middleware.JWTAuth(), middleware.ModeratorOnly())
+		{
+			moderation.PUT("/:id", handlers.RenameHashtag)
+			moderation.DELETE("/:id", handlers.DeleteHashtag)
+			moderation.POST("/:id/merge", handlers.MergeHashtag)
+			moderation.GET("/:id/aliases", handlers.GetHashtagAliases)
+			moderation.POST("/:id/aliases", handlers.CreateHashtagAlias)
+			moderation.DELETE("/:id/aliases/:alias_id", handlers.DeleteHashtagAlias)
+			moderation.POST("/:id/ban", handlers.BanHashtag)
+			moderation.POST("/:id/unban", handlers.UnbanHashtag)
+		}
+	}
+
+	// ================= LEADERBOARDS =================
+	r.GET("/leaderboards/:metric", middleware.OptionalJWTAuth(), handlers.GetLeaderboard)
+
+	// ================= ACHIEVEMENTS (ADMIN) =================
+	achievementsAdmin := r.Group("/achievements")
+	achievementsAdmin.Use(middleware.JWTAuth(), middleware.ModeratorOnly())
+	{
+		achievementsAdmin.GET("", handlers.ListAchievements)
+		achievementsAdmin.POST("", handlers.CreateAchievement)
+		achievementsAdmin.POST("/create", handlers.CreateAchievement)
+		achievementsAdmin.PUT("/:id", handlers.UpdateAchievement)
+		achievementsAdmin.POST("/:id/archive", handlers.ArchiveAchievement)
+		achievementsAdmin.POST("/:id/unarchive", handlers.UnarchiveAchievement)
+		achievementsAdmin.POST("/:id/icon", handlers.UploadAchievementIcon)
+		achievementsAdmin.POST("/:id/grant", handlers.GrantAchievement)
+		achievementsAdmin.POST("/:id/revoke", handlers.RevokeAchievement)
+		achievementsAdmin.POST("/recompute", handlers.RecomputeAchievements)
+		achievementsAdmin.GET("/recompute/:id", handlers.GetAchievementRecompute)
 	}
 
 	// ================= WS =================
@@ -212,7 +345,78 @@ func main() {
 	log.Println("🛑 Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	srv.Shutdown(ctx)
+	// Сначала дожидаемся запросов в обработке: они ещё публикуют события и пишут в базу
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("HTTP shutdown: %v", err)
+	}
+	// Hijacked WebSocket-соединения srv.Shutdown не закрывает — закрываем сами
+	if err := wsservice.Shutdown(ctx); err != nil {
+		log.Printf("WebSocket shutdown: %v", err)
+	}
+	handlers.ClearPresence(db)
+	// Только теперь останавливаем воркеры и дочитываем очереди событий
+	stopWorkers()
+	if err := events.Wait(ctx); err != nil {
+		log.Printf("Events: shutdown before queues were drained: %v", err)
+	}
+	if err := handlers.WaitAchievementRewards(ctx); err != nil {
+		log.Printf("Achievements: shutdown before rewards were sent: %v", err)
+	}
 
 	log.Println("✅ Server stopped")
 }
+
+// recomputeAchievementsCLI пересчитывает одну ачивку (по key) или все; Ctrl+C сохраняет курсор,
+// повторный запуск продолжит с того же места
+func recomputeAchievementsCLI(db *gorm.DB, args []string) int {
+	var achievementID *uint
+	if len(args) > 0 {
+		var ach models.Achievement
+		if err := db.Where("key = ?", args[0]).First(&ach).Error; err != nil {
+			log.Printf("Achievement %q not found", args[0])
+			return 1
+		}
+		achievementID = &ach.ID
+	}
+
+	job, err := handlers.StartAchievementRecompute(db, achievementID)
+	if err != nil {
+		log.Printf("Failed to start recompute: %v", err)
+		return 1
+	}
+	log.Printf("Recompute #%d: starting after user %d (%d/%d processed)", job.ID, job.LastUserID, job.ProcessedUsers, job.TotalUsers)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	done := make(chan error, 1)
+	go func() { done <- handlers.RunAchievementRecompute(ctx, db, job.ID) }()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			// Опыт и уведомления за ачивки, открытые в конце пересчёта, ещё могут отправляться
+			waitCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := handlers.WaitAchievementRewards(waitCtx); err != nil {
+				log.Printf("Recompute #%d: some achievement rewards were not sent: %v", job.ID, err)
+			}
+			cancel()
+
+			db.First(&job, job.ID)
+			log.Printf("Recompute #%d %s: %d/%d users, %d unlocked", job.ID, job.Status, job.ProcessedUsers, job.TotalUsers, job.Unlocked)
+			if err != nil {
+				log.Printf("Recompute stopped: %v", err)
+				return 1
+			}
+			if job.Status != "done" {
+				log.Printf("Recompute #%d is being run by another process", job.ID)
+			}
+			return 0
+		case <-ticker.C:
+			db.First(&job, job.ID)
+			log.Printf("Recompute #%d: %d/%d users, %d unlocked", job.ID, job.ProcessedUsers, job.TotalUsers, job.Unlocked)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"go_stories_api/models"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ModeratorOnly пропускает только модераторов (profiles.is_moderator).
+// Ставится после JWTAuth и DatabaseMiddleware.
+func ModeratorOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("db").(*gorm.DB)
+		userID := c.MustGet("user_id").(uint)
+
+		var profile models.Profile
+		if err := db.Where("user_id = ?", userID).First(&profile).Error; err != nil || !profile.IsModerator {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Moderator access required"})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -23,6 +23,8 @@ type User struct {
 	Likes      []Like      `gorm:"foreignKey:UserID" json:"likes,omitempty"`
 	Followers  []Subscription `gorm:"foreignKey:FollowingID" json:"followers,omitempty"`
 	Following  []Subscription `gorm:"foreignKey:FollowerID" json:"following,omitempty"`
+
+	Badges []Badge `gorm:"-" json:"badges,omitempty"` // витрина ачивок, заполняется в обработчиках
 }
 
 type Profile struct {
@@ -32,6 +34,11 @@ type Profile struct {
 	Bio          string    `gorm:"type:text" json:"bio"`
 	IsVerified   bool      `gorm:"default:false" json:"is_verified"`
 	IsEarly      bool      `gorm:"default:false" json:"is_early"`
+	IsModerator  bool      `gorm:"default:false" json:"is_moderator"`
+	TimeZone     string    `gorm:"size:64;default:UTC" json:"time_zone"` // IANA, например Europe/Moscow
+	Language     string    `gorm:"size:5;default:ru" json:"language"`     // язык писем: ru, en
+	ShowPresence bool      `gorm:"default:true" json:"show_presence"`    // показывать другим "в сети" и время последнего визита
+	LastSeenAt   *time.Time `json:"-"`                                   // отдаётся только через /users/:id/presence
 	OtpCode      string    `gorm:"size:6" json:"-"`
 	OtpCreatedAt time.Time `json:"-"`
 	CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
@@ -39,6 +46,11 @@ type Profile struct {
 	StreakCount      int       `gorm:"default:0" json:"streak_count"`
 	LastActiveAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"last_active_at"`
     StreakRewarded   bool      `gorm:"default:false" json:"streak_rewarded"`
+	StreakDay     string   `gorm:"size:10" json:"streak_day"`         // последний засчитанный день серии, YYYY-MM-DD по часовому поясу пользователя
+	LongestStreak int      `gorm:"default:0" json:"longest_streak"`
+	StreakFreezes int      `gorm:"default:0" json:"streak_freezes"` // заморозки: каждая закрывает один пропущенный день
+	XP           int64     `gorm:"default:0" json:"xp"`
+	Level        int       `gorm:"default:1" json:"level"` // считается из XP, см. achievements.LevelFor
 }
 
 // models/story.go - ДОБАВИТЬ ПОЛЯ:
@@ -69,6 +81,7 @@ type Story struct {
 	Comments  []Comment      `gorm:"foreignKey:StoryID" json:"comments,omitempty"`
 	Likes     []Like         `gorm:"foreignKey:StoryID" json:"likes,omitempty"`
 	Hashtags  []StoryHashtag `gorm:"foreignKey:StoryID" json:"hashtags,omitempty"`
+	Mentions  []Mention      `gorm:"foreignKey:StoryID" json:"mentions,omitempty"`
 }
 type Comment struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
@@ -77,10 +90,28 @@ type Comment struct {
 	Content   string    `gorm:"type:text;not null" json:"content"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+
+	// Ветки комментариев
+	ParentID   *uint `gorm:"index" json:"parent_id"`          // null для верхнего уровня
+	ReplyCount int   `gorm:"default:0" json:"reply_count"`    // прямые ответы
+	LikesCount int   `gorm:"default:0" json:"likes_count"`
+	IsDeleted  bool  `gorm:"default:false" json:"is_deleted"` // удалён, но остался ради ответов
 	
 	// Отношения
-	User  User  `gorm:"foreignKey:UserID" json:"user"`
-	Story Story `gorm:"foreignKey:StoryID" json:"story"`
+	User     User      `gorm:"foreignKey:UserID" json:"user"`
+	Story    Story     `gorm:"foreignKey:StoryID" json:"story"`
+	Mentions []Mention `gorm:"foreignKey:CommentID" json:"mentions,omitempty"`
+
+	// Заполняются при выдаче дерева
+	Replies []Comment `gorm:"-" json:"replies,omitempty"`
+	Liked   bool      `gorm:"-" json:"liked"`
+}
+
+type CommentLike struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_comment" json:"user_id"`
+	CommentID uint      `gorm:"not null;uniqueIndex:idx_user_comment;index" json:"comment_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
 type Like struct {
@@ -97,20 +128,64 @@ type Subscription struct {
 	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
+// Блокировка: BlockerID не хочет видеть BlockedID
+type UserBlock struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	BlockerID uint      `gorm:"not null;uniqueIndex:idx_blocker_blocked" json:"blocker_id"`
+	BlockedID uint      `gorm:"not null;uniqueIndex:idx_blocker_blocked;index" json:"blocked_id"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// @упоминание в истории (StoryID) или комментарии (CommentID)
+type Mention struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`   // кого упомянули
+	AuthorID  uint      `gorm:"not null;index" json:"author_id"` // кто упомянул
+	StoryID   *uint     `gorm:"index" json:"story_id,omitempty"`
+	CommentID *uint     `gorm:"index" json:"comment_id,omitempty"`
+	Field     string    `gorm:"size:20;not null" json:"field"` // title / content
+	Offset    int       `gorm:"not null" json:"offset"`        // в символах, включая '@'
+	Length    int       `gorm:"not null" json:"length"`
+	Username  string    `gorm:"size:150;not null" json:"username"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
 type Hashtag struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Name      string    `gorm:"uniqueIndex;size:100;not null" json:"name"`
+	IsBanned  bool      `gorm:"default:false;index" json:"is_banned"` // забаненный тег нельзя создать или привязать
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
 	
 	// Отношения
 	Stories []StoryHashtag `gorm:"foreignKey:HashtagID" json:"stories,omitempty"`
 }
 
+// Синоним хештега: #js -> #javascript
+type HashtagAlias struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"uniqueIndex;size:100;not null" json:"name"`
+	HashtagID uint      `gorm:"not null;index" json:"hashtag_id"` // канонический тег
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
 type StoryHashtag struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	StoryID   uint      `gorm:"not null;uniqueIndex:idx_story_hashtag" json:"story_id"`
 	HashtagID uint      `gorm:"not null;uniqueIndex:idx_story_hashtag" json:"hashtag_id"`
+	Inline    bool      `gorm:"default:false" json:"inline"` // тег найден в тексте истории
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	Hashtag *Hashtag `gorm:"foreignKey:HashtagID" json:"hashtag,omitempty"`
+}
+
+// Подписка пользователя на хештег
+type HashtagFollow struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_hashtag" json:"user_id"`
+	HashtagID uint      `gorm:"not null;uniqueIndex:idx_user_hashtag;index" json:"hashtag_id"`
 	CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+
+	Hashtag Hashtag `gorm:"foreignKey:HashtagID" json:"hashtag"`
 }
 
 type NotInterested struct {
@@ -153,22 +228,252 @@ type Achievement struct {
 	Key         string         `gorm:"uniqueIndex;size:100;not null" json:"key"`
 	Title       string         `gorm:"size:255;not null" json:"title"`
 	Description string         `gorm:"type:text" json:"description"`
-	Icon        string         `gorm:"size:500" json:"icon"`        // URL иконки
+	Icon        string         `gorm:"size:500" json:"icon"`        // URL иконки (загруженные — /media/achievements/...)
 	Condition   datatypes.JSON `gorm:"type:jsonb" json:"condition"` // условие для прогресса
+	XP          int            `gorm:"default:0" json:"xp"`         // опыт за получение (для ачивок без уровней)
+
+	// Уровни bronze/silver/gold по одной метрике условия; пусто — обычная ачивка
+	Tiers datatypes.JSONSlice[AchievementTier] `gorm:"type:jsonb" json:"tiers,omitempty"`
+
+	Category  string `gorm:"size:50;index" json:"category"`
+	SortOrder int    `gorm:"default:0" json:"sort_order"` // порядок в списке внутри категории
+	// Секретная ачивка: не показывается в списке, пока не получена
+	Hidden bool `gorm:"default:false" json:"hidden"`
+	// Архивная ачивка больше не выдаётся, но остаётся у тех, кто её получил
+	ArchivedAt *time.Time `gorm:"index" json:"archived_at,omitempty"`
 
 	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
 }
 
+// Уровень многоуровневой ачивки: порог метрики и опыт за его достижение
+type AchievementTier struct {
+	Name      string  `json:"name"` // bronze, silver, gold
+	Threshold float64 `json:"threshold"`
+	XP        int     `json:"xp"`
+}
+
 type UserAchievement struct {
 	ID            uint       `gorm:"primaryKey" json:"id"`
-	UserID        uint       `gorm:"not null;index" json:"user_id"`
-	AchievementID uint       `gorm:"not null;index" json:"achievement_id"`
+	UserID        uint       `gorm:"not null;index;uniqueIndex:idx_user_achievement" json:"user_id"`
+	AchievementID uint       `gorm:"not null;index;uniqueIndex:idx_user_achievement" json:"achievement_id"`
 	Progress      float64    `gorm:"default:0" json:"progress"` // 0..1
 	Unlocked      bool       `gorm:"default:false" json:"unlocked"`
+	Tier          string     `gorm:"size:20" json:"tier,omitempty"` // достигнутый уровень многоуровневой ачивки
+	UnlockedAt    *time.Time `json:"unlocked_at"`
+	Showcase      int        `gorm:"default:0" json:"showcase"` // место на витрине профиля (1..N), 0 — не выставлена
+	Revoked       bool       `gorm:"default:false" json:"revoked"` // отозвана модератором: условие её больше не выдаёт
 	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
 	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
 
 	User        User        `gorm:"foreignKey:UserID" json:"user"`
 	Achievement Achievement `gorm:"foreignKey:AchievementID" json:"achievement"`
-}
\ No newline at end of file
+}
+
+// Ачивка на витрине профиля, как она отдаётся вместе с пользователем
+type Badge struct {
+	AchievementID uint       `json:"achievement_id"`
+	Key           string     `json:"key"`
+	Title         string     `json:"title"`
+	Icon          string     `json:"icon"`
+	Tier          string     `json:"tier,omitempty"`
+	Position      int        `json:"position"`
+	UnlockedAt    *time.Time `json:"unlocked_at"`
+}
+
+// Кэш трендов, пересчитывается фоновым воркером
+type TrendingHashtag struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	HashtagID    uint      `gorm:"not null;uniqueIndex:idx_trending_hashtag_period" json:"hashtag_id"`
+	Period       string    `gorm:"size:10;not null;uniqueIndex:idx_trending_hashtag_period;index" json:"period"` // 1h, 24h, 7d
+	Score        float64   `gorm:"default:0" json:"score"`
+	StoriesCount int64     `gorm:"default:0" json:"stories_count"` // новых историй с тегом за период
+	ComputedAt   time.Time `json:"computed_at"`
+
+	Hashtag Hashtag `gorm:"foreignKey:HashtagID" json:"hashtag"`
+}
+
+type TrendingStory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	StoryID    uint      `gorm:"not null;uniqueIndex:idx_trending_story_period" json:"story_id"`
+	Period     string    `gorm:"size:10;not null;uniqueIndex:idx_trending_story_period;index" json:"period"`
+	Score      float64   `gorm:"default:0" json:"score"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// Уведомление во входящих. Однотипные события (лайки, подписки...) группируются
+// в одну запись, пока она не прочитана: "5 people liked your story".
+type Notification struct {
+	ID          uint                      `gorm:"primaryKey" json:"id"`
+	UserID      uint                      `gorm:"not null;index" json:"user_id"`     // получатель
+	Type        string                    `gorm:"size:50;not null" json:"type"`      // follow, reply, like, comment, mention, achievement
+	ActorID     *uint                     `json:"actor_id"`                          // последний, кто вызвал событие
+	TargetType  string                    `gorm:"size:50" json:"target_type"`        // story, comment, user, achievement
+	TargetID    *uint                     `json:"target_id"`
+	GroupKey    string                    `gorm:"size:150;index" json:"-"`
+	ActorIDs    datatypes.JSONSlice[uint] `gorm:"type:jsonb" json:"actor_ids"`
+	ActorsCount int                       `gorm:"default:1" json:"actors_count"`
+	Message     string                    `gorm:"size:500" json:"message"`
+	Payload     datatypes.JSON            `gorm:"type:jsonb" json:"payload"`
+	ReadAt      *time.Time                `gorm:"index" json:"read_at"`
+	CreatedAt   time.Time                 `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt   time.Time                 `gorm:"autoUpdateTime;index" json:"updated_at"`
+
+	Actor *User `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+}
+
+// Переопределение настройки уведомлений: событие × канал. Если записи нет — действует значение по умолчанию.
+type NotificationPreference struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_user_event_channel" json:"user_id"`
+	EventType string    `gorm:"size:50;not null;uniqueIndex:idx_user_event_channel" json:"event_type"`
+	Channel   string    `gorm:"size:20;not null;uniqueIndex:idx_user_event_channel" json:"channel"` // in_app, websocket, push, email
+	Enabled   bool      `gorm:"not null" json:"enabled"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Общие настройки уведомлений пользователя
+type NotificationSettings struct {
+	ID                uint       `gorm:"primaryKey" json:"id"`
+	UserID            uint       `gorm:"uniqueIndex;not null" json:"user_id"`
+	QuietHoursEnabled bool       `gorm:"default:false" json:"quiet_hours_enabled"`
+	QuietHoursStart   string     `gorm:"size:5;default:'22:00'" json:"quiet_hours_start"` // HH:MM по времени пользователя
+	QuietHoursEnd     string     `gorm:"size:5;default:'08:00'" json:"quiet_hours_end"`
+	UnsubscribeToken  string     `gorm:"size:64;uniqueIndex;not null" json:"-"` // для ссылок "отписаться" в письмах
+	LastDigestAt      *time.Time `json:"last_digest_at"`                        // когда последний раз собирался еженедельный дайджест
+	CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt         time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Письмо в очереди на отправку. Содержимое рендерится при постановке в очередь.
+type EmailOutbox struct {
+	ID            uint           `gorm:"primaryKey" json:"id"`
+	UserID        *uint          `gorm:"index" json:"user_id"`
+	To            string         `gorm:"size:254;not null" json:"to"`
+	Subject       string         `gorm:"size:255;not null" json:"subject"`
+	TextBody      string         `gorm:"type:text" json:"text_body"`
+	HTMLBody      string         `gorm:"type:text" json:"html_body"`
+	Headers       datatypes.JSON `json:"headers"`
+	Template      string         `gorm:"size:50" json:"template"`
+	Status        string         `gorm:"size:20;not null;default:pending;index:idx_outbox_status_next" json:"status"` // pending, sent, failed
+	Attempts      int            `gorm:"default:0" json:"attempts"`
+	NextAttemptAt time.Time      `gorm:"index:idx_outbox_status_next" json:"next_attempt_at"`
+	LastError     string         `gorm:"type:text" json:"last_error"`
+	SentAt        *time.Time     `json:"sent_at"`
+	CreatedAt     time.Time      `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time      `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Соединения пользователя на одном экземпляре API. Строки обновляются воркером присутствия;
+// строка, которую давно не обновляли, принадлежит упавшему экземпляру.
+type UserPresence struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	UserID      uint      `gorm:"not null;uniqueIndex:idx_presence_user_instance" json:"user_id"`
+	InstanceID  string    `gorm:"size:32;not null;uniqueIndex:idx_presence_user_instance" json:"instance_id"`
+	Connections int       `gorm:"not null" json:"connections"`
+	UpdatedAt   time.Time `gorm:"index" json:"updated_at"`
+}
+
+// Кто сейчас читает историю (подписан на её канал по WebSocket)
+type StoryReader struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	StoryID    uint      `gorm:"not null;uniqueIndex:idx_story_reader" json:"story_id"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_story_reader" json:"user_id"`
+	InstanceID string    `gorm:"size:32;not null;uniqueIndex:idx_story_reader" json:"instance_id"`
+	UpdatedAt  time.Time `gorm:"index" json:"updated_at"`
+}
+
+// Личная переписка двух пользователей. UserAID < UserBID, чтобы у пары был один диалог.
+type Conversation struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	UserAID       uint       `gorm:"not null;uniqueIndex:idx_conversation_pair" json:"user_a_id"`
+	UserBID       uint       `gorm:"not null;uniqueIndex:idx_conversation_pair;index" json:"user_b_id"`
+	Status        string     `gorm:"size:20;not null;default:active" json:"status"` // active, request (от неподписанного), declined
+	RequestedBy   *uint      `json:"requested_by"`                                  // кто начал переписку-запрос
+	LastMessageAt *time.Time `gorm:"index" json:"last_message_at"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+
+	UserA       *User    `gorm:"foreignKey:UserAID" json:"user_a,omitempty"`
+	UserB       *User    `gorm:"foreignKey:UserBID" json:"user_b,omitempty"`
+	LastMessage *Message `gorm:"-" json:"last_message,omitempty"`
+	UnreadCount int64    `gorm:"-" json:"unread_count"`
+}
+
+// Сообщение в личной переписке
+type Message struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ConversationID uint       `gorm:"not null;index:idx_message_conversation" json:"conversation_id"`
+	SenderID       uint       `gorm:"not null" json:"sender_id"`
+	Content        string     `gorm:"type:text;not null" json:"content"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	ReadAt         *time.Time `json:"read_at"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime;index:idx_message_conversation" json:"created_at"`
+
+	Sender *User `gorm:"foreignKey:SenderID" json:"sender,omitempty"`
+}
+
+// Пересчёт прогресса ачивок по всем пользователям. Курсор LastUserID позволяет
+// продолжить прерванный пересчёт с того же места.
+type AchievementRecompute struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	AchievementID  *uint      `gorm:"index" json:"achievement_id"` // nil — все ачивки с условием
+	Status         string     `gorm:"size:20;not null;default:pending;index" json:"status"` // pending, running, done, failed
+	LastUserID     uint       `gorm:"default:0" json:"last_user_id"`
+	TotalUsers     int64      `gorm:"default:0" json:"total_users"`
+	ProcessedUsers int64      `gorm:"default:0" json:"processed_users"`
+	Unlocked       int64      `gorm:"default:0" json:"unlocked"` // сколько ачивок открыл этот пересчёт
+	LastError      string     `gorm:"type:text" json:"last_error,omitempty"`
+	ClaimedBy      string     `gorm:"size:32" json:"-"` // токен запуска, который сейчас ведёт пересчёт
+	StartedAt      *time.Time `json:"started_at"`
+	FinishedAt     *time.Time `json:"finished_at"`
+	CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// Начисление опыта. Key делает начисление идемпотентным: за одно и то же
+// (story:12, like:12:5, achievement:3:gold) опыт даётся один раз.
+type XPEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_xp_user_key;index:idx_xp_user_created" json:"user_id"`
+	Key       string    `gorm:"size:100;not null;uniqueIndex:idx_xp_user_key" json:"key"`
+	Reason    string    `gorm:"size:30;not null" json:"reason"` // achievement, story, reply, like, follow, comment, streak
+	Amount    int       `gorm:"not null" json:"amount"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index:idx_xp_user_created" json:"created_at"`
+}
+
+// Место пользователя в таблице лидеров. Пересчитывается воркером целиком,
+// HashtagID = 0 — общая таблица.
+type LeaderboardEntry struct {
+	ID         uint      `gorm:"primaryKey" json:"-"`
+	Metric     string    `gorm:"size:30;not null;uniqueIndex:idx_leaderboard_user;index:idx_leaderboard_rank" json:"metric"`
+	Period     string    `gorm:"size:10;not null;uniqueIndex:idx_leaderboard_user;index:idx_leaderboard_rank" json:"period"`
+	HashtagID  uint      `gorm:"not null;default:0;uniqueIndex:idx_leaderboard_user;index:idx_leaderboard_rank" json:"hashtag_id"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_leaderboard_user" json:"user_id"`
+	Rank       int       `gorm:"not null;index:idx_leaderboard_rank" json:"rank"`
+	Score      float64   `gorm:"not null" json:"score"`
+	ComputedAt time.Time `json:"computed_at"`
+
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// Активность пользователя за календарный день (по его часовому поясу) — история серии.
+// Frozen — день пропущен, но закрыт заморозкой.
+type StreakActivity struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_streak_user_day" json:"user_id"`
+	Day       string    `gorm:"size:10;not null;uniqueIndex:idx_streak_user_day" json:"day"` // YYYY-MM-DD
+	Count     int       `gorm:"default:0" json:"count"`
+	Frozen    bool      `gorm:"default:false" json:"frozen"`
+	CreatedAt time.Time `gorm:"autoCreateTime" json:"-"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime" json:"-"`
+}
+
+// Достигнутая веха серии (3, 7, 30... дней). Награда за каждую веху выдаётся один раз.
+type StreakMilestone struct {
+	ID        uint      `gorm:"primaryKey" json:"-"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_streak_milestone_user_days" json:"user_id"`
+	Days      int       `gorm:"not null;uniqueIndex:idx_streak_milestone_user_days" json:"days"`
+	XP        int       `gorm:"default:0" json:"xp"`
+	ReachedAt time.Time `gorm:"autoCreateTime" json:"reached_at"`
+}
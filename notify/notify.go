@@ -0,0 +1,261 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"go_stories_api/blocks"
+	"go_stories_api/models"
+	"go_stories_api/prefs"
+	"go_stories_api/wsservice"
+	"log"
+	"time"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Типы уведомлений
+const (
+	TypeFollow      = "follow"
+	TypeReply       = "reply"
+	TypeLike        = "like"
+	TypeComment     = "comment"
+	TypeMention     = "mention"
+	TypeAchievement = "achievement"
+	TypeLevelUp     = "level_up"
+	TypeStreak      = "streak_milestone"
+)
+
+// Типы объектов, к которым относится уведомление
+const (
+	TargetStory       = "story"
+	TargetComment     = "comment"
+	TargetUser        = "user"
+	TargetAchievement = "achievement"
+)
+
+// Эти типы схлопываются в одну запись, пока она не прочитана
+var groupedTypes = map[string]bool{
+	TypeFollow:  true,
+	TypeLike:    true,
+	TypeComment: true,
+	TypeReply:   true,
+}
+
+// Сколько последних актёров храним в группе
+const maxGroupActors = 50
+
+// Event — то, что произошло. ActorID == 0 для системных событий (ачивки).
+type Event struct {
+	UserID     uint
+	ActorID    uint
+	Type       string
+	TargetType string
+	TargetID   uint
+	Payload    map[string]interface{}
+}
+
+// Send сохраняет уведомление во входящих получателя и отправляет его по WebSocket.
+// Уведомления самому себе и между заблокированными пользователями не создаются.
+// Каналы in_app и websocket учитывают настройки получателя.
+func Send(db *gorm.DB, e Event) (*models.Notification, error) {
+	if e.UserID == 0 || (e.ActorID != 0 && e.ActorID == e.UserID) {
+		return nil, nil
+	}
+	if e.ActorID != 0 && blocks.Between(db, e.ActorID, e.UserID) {
+		return nil, nil
+	}
+
+	inApp := prefs.Allowed(db, e.UserID, e.Type, prefs.ChannelInApp)
+	realtime := prefs.Allowed(db, e.UserID, e.Type, prefs.ChannelWebSocket)
+	if !inApp && !realtime {
+		return nil, nil
+	}
+
+	var actor models.User
+	if e.ActorID != 0 {
+		if err := db.First(&actor, e.ActorID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if !inApp {
+		// Во входящие не сохраняем, но в реальном времени показываем
+		n := transient(e, actor)
+		push(db, &n)
+		return nil, nil
+	}
+
+	var notification models.Notification
+	changed := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		groupKey := ""
+		if groupedTypes[e.Type] {
+			groupKey = fmt.Sprintf("%s:%s:%d", e.Type, e.TargetType, e.TargetID)
+		}
+
+		notification = models.Notification{
+			UserID:      e.UserID,
+			Type:        e.Type,
+			TargetType:  e.TargetType,
+			GroupKey:    groupKey,
+			ActorsCount: 1,
+			ActorIDs:    datatypes.JSONSlice[uint]{},
+		}
+		if e.ActorID != 0 {
+			notification.ActorID = &e.ActorID
+			notification.ActorIDs = append(notification.ActorIDs, e.ActorID)
+		}
+		if e.TargetID != 0 {
+			notification.TargetID = &e.TargetID
+		}
+		if e.Payload != nil {
+			payload, _ := json.Marshal(e.Payload)
+			notification.Payload = datatypes.JSON(payload)
+		}
+		notification.Message = message(notification, actor.Username, e.Payload)
+
+		if groupKey == "" {
+			changed = true
+			return tx.Create(&notification).Error
+		}
+
+		// Непрочитанная группа уникальна (idx_notifications_unread_group): из параллельных
+		// событий вставит только одно, остальные дописывают автора в уже созданную
+		created := tx.Clauses(clause.OnConflict{
+			Columns:     []clause.Column{{Name: "user_id"}, {Name: "group_key"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: unreadGroupPredicate}}},
+			DoNothing:   true,
+		}).Create(&notification)
+		if created.Error != nil || created.RowsAffected > 0 {
+			changed = created.Error == nil
+			return created.Error
+		}
+
+		notification = models.Notification{}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND group_key = ? AND read_at IS NULL", e.UserID, groupKey).
+			First(&notification).Error; err != nil {
+			return err
+		}
+		var err error
+		changed, err = appendActor(tx, &notification, e, actor)
+		return err
+	})
+	if err != nil {
+		log.Printf("notify: failed to save %s notification for user %d: %v", e.Type, e.UserID, err)
+		return nil, err
+	}
+
+	if changed && realtime {
+		push(db, &notification)
+	}
+	return &notification, nil
+}
+
+// unreadGroupPredicate — условие частичного индекса idx_notifications_unread_group (см. database.MigrateDB)
+const unreadGroupPredicate = "read_at IS NULL AND group_key <> ''"
+
+// transient собирает уведомление без сохранения в базу
+func transient(e Event, actor models.User) models.Notification {
+	n := models.Notification{
+		UserID:      e.UserID,
+		Type:        e.Type,
+		TargetType:  e.TargetType,
+		ActorsCount: 1,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if e.ActorID != 0 {
+		n.ActorID = &e.ActorID
+		n.ActorIDs = datatypes.JSONSlice[uint]{e.ActorID}
+	}
+	if e.TargetID != 0 {
+		n.TargetID = &e.TargetID
+	}
+	if e.Payload != nil {
+		payload, _ := json.Marshal(e.Payload)
+		n.Payload = datatypes.JSON(payload)
+	}
+	n.Message = message(n, actor.Username, e.Payload)
+	return n
+}
+
+// appendActor добавляет актёра в существующую группу (повторный лайк того же человека не считается)
+func appendActor(tx *gorm.DB, n *models.Notification, e Event, actor models.User) (bool, error) {
+	for _, id := range n.ActorIDs {
+		if id == e.ActorID {
+			return false, nil
+		}
+	}
+
+	n.ActorIDs = append(n.ActorIDs, e.ActorID)
+	if len(n.ActorIDs) > maxGroupActors {
+		n.ActorIDs = n.ActorIDs[len(n.ActorIDs)-maxGroupActors:]
+	}
+	n.ActorsCount++
+	n.ActorID = &e.ActorID
+	n.Message = message(*n, actor.Username, e.Payload)
+	n.UpdatedAt = time.Now()
+
+	err := tx.Model(n).Select("actor_ids", "actors_count", "actor_id", "message", "updated_at").Updates(n).Error
+	return err == nil, err
+}
+
+// push отправляет уведомление и счётчик непрочитанных по WebSocket
+func push(db *gorm.DB, n *models.Notification) {
+	wsservice.SendNotification(n.UserID, map[string]interface{}{
+		"type":         n.Type,
+		"message":      n.Message,
+		"notification": n,
+		"unread_count": UnreadCount(db, n.UserID),
+	})
+}
+
+// PushUnreadCount отправляет по WebSocket новый счётчик непрочитанных (после прочтения)
+func PushUnreadCount(db *gorm.DB, userID uint) {
+	wsservice.SendToUser(userID, wsservice.EventUnreadCount, map[string]interface{}{
+		"unread_count": UnreadCount(db, userID),
+	})
+}
+
+// UnreadCount — количество непрочитанных уведомлений пользователя
+func UnreadCount(db *gorm.DB, userID uint) int64 {
+	var count int64
+	db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// message собирает текст уведомления с учётом группировки
+func message(n models.Notification, actorName string, payload map[string]interface{}) string {
+	who := "@" + actorName
+	if n.ActorsCount > 1 {
+		who = fmt.Sprintf("%d people", n.ActorsCount)
+	}
+
+	switch n.Type {
+	case TypeFollow:
+		return who + " followed you"
+	case TypeLike:
+		if n.TargetType == TargetComment {
+			return who + " liked your comment"
+		}
+		return who + " liked your story"
+	case TypeComment:
+		return who + " commented on your story"
+	case TypeReply:
+		if n.TargetType == TargetComment {
+			return who + " replied to your comment"
+		}
+		return who + " replied to your story"
+	case TypeMention:
+		return who + " mentioned you"
+	case TypeAchievement:
+		if title, ok := payload["title"].(string); ok {
+			return "Achievement unlocked: " + title
+		}
+		return "Achievement unlocked"
+	}
+	return "New notification"
+}
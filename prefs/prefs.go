@@ -0,0 +1,300 @@
+package prefs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"go_stories_api/models"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Каналы доставки
+const (
+	ChannelInApp     = "in_app"
+	ChannelWebSocket = "websocket"
+	ChannelPush      = "push"
+	ChannelEmail     = "email"
+)
+
+var Channels = []string{ChannelInApp, ChannelWebSocket, ChannelPush, ChannelEmail}
+
+// Значения по умолчанию: событие -> канал -> включено
+var defaults = map[string]map[string]bool{
+	"follow":           {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: true, ChannelEmail: true},
+	"unfollow":         {ChannelInApp: false, ChannelWebSocket: false, ChannelPush: false, ChannelEmail: true},
+	"reply":            {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: true, ChannelEmail: false},
+	"like":             {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: false, ChannelEmail: false},
+	"comment":          {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: true, ChannelEmail: false},
+	"mention":          {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: true, ChannelEmail: false},
+	"achievement":      {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: true, ChannelEmail: false},
+	"level_up":         {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: false, ChannelEmail: false},
+	"streak_milestone": {ChannelInApp: true, ChannelWebSocket: true, ChannelPush: true, ChannelEmail: false},
+	"new_story":        {ChannelInApp: false, ChannelWebSocket: false, ChannelPush: true, ChannelEmail: false},
+	"digest":           {ChannelEmail: true},
+	"message":          {ChannelPush: true},
+}
+
+// Events — все типы событий, которыми можно управлять
+func Events() []string {
+	return []string{"follow", "unfollow", "reply", "like", "comment", "mention", "achievement", "level_up", "streak_milestone", "new_story", "digest", "message"}
+}
+
+// Valid проверяет пару событие/канал
+func Valid(event, channel string) bool {
+	_, ok := defaults[event][channel]
+	return ok
+}
+
+// Default — значение без пользовательских настроек
+func Default(event, channel string) bool {
+	return defaults[event][channel]
+}
+
+var publicURL = struct {
+	sync.RWMutex
+	value string
+}{}
+
+// SetPublicURL задаёт внешний адрес API для ссылок отписки
+func SetPublicURL(u string) {
+	publicURL.Lock()
+	defer publicURL.Unlock()
+	publicURL.value = strings.TrimRight(u, "/")
+}
+
+// Allowed — хочет ли пользователь получать событие по каналу
+func Allowed(db *gorm.DB, userID uint, event, channel string) bool {
+	var pref models.NotificationPreference
+	err := db.Where("user_id = ? AND event_type = ? AND channel = ?", userID, event, channel).First(&pref).Error
+	if err != nil {
+		return Default(event, channel)
+	}
+	return pref.Enabled
+}
+
+// FilterUsers оставляет тех, кому можно отправить событие по каналу.
+// Для push дополнительно учитываются тихие часы.
+func FilterUsers(db *gorm.DB, userIDs []uint, event, channel string) []uint {
+	if len(userIDs) == 0 {
+		return userIDs
+	}
+
+	var overrides []models.NotificationPreference
+	db.Where("user_id IN ? AND event_type = ? AND channel = ?", userIDs, event, channel).Find(&overrides)
+	enabled := make(map[uint]bool)
+	for _, o := range overrides {
+		enabled[o.UserID] = o.Enabled
+	}
+
+	result := make([]uint, 0, len(userIDs))
+	for _, id := range userIDs {
+		allowed, overridden := enabled[id]
+		if !overridden {
+			allowed = Default(event, channel)
+		}
+		if allowed {
+			result = append(result, id)
+		}
+	}
+	if channel != ChannelPush || len(result) == 0 {
+		return result
+	}
+
+	quiet := quietUsers(db, result, time.Now())
+	if len(quiet) == 0 {
+		return result
+	}
+	awake := result[:0]
+	for _, id := range result {
+		if !quiet[id] {
+			awake = append(awake, id)
+		}
+	}
+	return awake
+}
+
+// quietUsers одним запросом находит, у кого из userIDs сейчас тихие часы
+func quietUsers(db *gorm.DB, userIDs []uint, now time.Time) map[uint]bool {
+	var rows []struct {
+		UserID          uint
+		QuietHoursStart string
+		QuietHoursEnd   string
+		TimeZone        string
+	}
+	db.Table("notification_settings").
+		Select("notification_settings.user_id, notification_settings.quiet_hours_start, notification_settings.quiet_hours_end, COALESCE(profiles.time_zone, '') AS time_zone").
+		Joins("LEFT JOIN profiles ON profiles.user_id = notification_settings.user_id").
+		Where("notification_settings.user_id IN ? AND notification_settings.quiet_hours_enabled = ?", userIDs, true).
+		Scan(&rows)
+
+	quiet := make(map[uint]bool)
+	locations := make(map[string]*time.Location)
+	for _, r := range rows {
+		loc, ok := locations[r.TimeZone]
+		if !ok {
+			loc = location(r.TimeZone)
+			locations[r.TimeZone] = loc
+		}
+		if quietAt(r.QuietHoursStart, r.QuietHoursEnd, now.In(loc)) {
+			quiet[r.UserID] = true
+		}
+	}
+	return quiet
+}
+
+// Settings возвращает общие настройки пользователя, создавая их при первом обращении
+func Settings(db *gorm.DB, userID uint) (models.NotificationSettings, error) {
+	var settings models.NotificationSettings
+	err := db.Where("user_id = ?", userID).First(&settings).Error
+	if err == gorm.ErrRecordNotFound {
+		settings = models.NotificationSettings{
+			UserID:           userID,
+			QuietHoursStart:  "22:00",
+			QuietHoursEnd:    "08:00",
+			UnsubscribeToken: newToken(),
+		}
+		err = db.Create(&settings).Error
+	}
+	return settings, err
+}
+
+// UserLocation — часовой пояс пользователя (UTC, если не задан или невалиден)
+func UserLocation(db *gorm.DB, userID uint) *time.Location {
+	var profile models.Profile
+	if err := db.Select("time_zone").Where("user_id = ?", userID).First(&profile).Error; err != nil {
+		return time.UTC
+	}
+	return location(profile.TimeZone)
+}
+
+// location разбирает часовой пояс профиля (UTC, если не задан или невалиден)
+func location(timeZone string) *time.Location {
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil || timeZone == "" {
+		return time.UTC
+	}
+	return loc
+}
+
+// InQuietHours — попадает ли момент now в тихие часы пользователя (по его часовому поясу)
+func InQuietHours(db *gorm.DB, userID uint, now time.Time) bool {
+	var settings models.NotificationSettings
+	if err := db.Where("user_id = ?", userID).First(&settings).Error; err != nil || !settings.QuietHoursEnabled {
+		return false
+	}
+
+	return quietAt(settings.QuietHoursStart, settings.QuietHoursEnd, now.In(UserLocation(db, userID)))
+}
+
+// quietAt — попадает ли местное время local в интервал тихих часов start-end (HH:MM)
+func quietAt(startClock, endClock string, local time.Time) bool {
+	start, err1 := ParseClock(startClock)
+	end, err2 := ParseClock(endClock)
+	if err1 != nil || err2 != nil || start == end {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// через полночь: 22:00 - 08:00
+	return minute >= start || minute < end
+}
+
+// ParseClock разбирает "HH:MM" в минуты от полуночи
+func ParseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// UnsubscribeURL — ссылка для отписки от писем об событии в один клик.
+// Пустой event — отписка от всех писем.
+func UnsubscribeURL(db *gorm.DB, userID uint, event string) string {
+	settings, err := Settings(db, userID)
+	if err != nil {
+		return ""
+	}
+
+	publicURL.RLock()
+	base := publicURL.value
+	publicURL.RUnlock()
+
+	query := url.Values{"token": {settings.UnsubscribeToken}}
+	if event != "" {
+		query.Set("type", event)
+	}
+	return base + "/unsubscribe?" + query.Encode()
+}
+
+// Unsubscribe выключает email для события (или всех событий) по токену из письма
+func Unsubscribe(db *gorm.DB, token, event string) error {
+	var settings models.NotificationSettings
+	if err := db.Where("unsubscribe_token = ?", token).First(&settings).Error; err != nil {
+		return err
+	}
+
+	events := Events()
+	if event != "" {
+		if !Valid(event, ChannelEmail) {
+			return fmt.Errorf("unknown event type %q", event)
+		}
+		events = []string{event}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, e := range events {
+			if err := Set(tx, settings.UserID, e, ChannelEmail, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Set сохраняет переопределение для события и канала
+func Set(db *gorm.DB, userID uint, event, channel string, enabled bool) error {
+	pref := models.NotificationPreference{
+		UserID:    userID,
+		EventType: event,
+		Channel:   channel,
+	}
+	return db.Where(pref).Assign(models.NotificationPreference{Enabled: enabled}).FirstOrCreate(&pref).Error
+}
+
+// Matrix — полная таблица настроек пользователя с учётом значений по умолчанию
+func Matrix(db *gorm.DB, userID uint) map[string]map[string]bool {
+	var overrides []models.NotificationPreference
+	db.Where("user_id = ?", userID).Find(&overrides)
+
+	matrix := make(map[string]map[string]bool)
+	for _, event := range Events() {
+		matrix[event] = make(map[string]bool)
+		for _, channel := range Channels {
+			if Valid(event, channel) {
+				matrix[event][channel] = Default(event, channel)
+			}
+		}
+	}
+	for _, o := range overrides {
+		if Valid(o.EventType, o.Channel) {
+			matrix[o.EventType][o.Channel] = o.Enabled
+		}
+	}
+	return matrix
+}
+
+func newToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,81 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// FakeSender хранит отправленные сообщения в памяти — для тестов и локальной разработки
+type FakeSender struct {
+	mu sync.Mutex
+
+	Sent          []FakeDelivery
+	Batches       [][]string      // токены каждого вызова Send — по ним видно пачки и повторы
+	BatchSize     int             // 0 — 100
+	InvalidTokens map[string]bool // эти токены "провайдер" отклонит как недействительные
+	Unavailable   map[string]int  // эти токены столько раз вернутся в RetryTokens с RetryableError
+	Err           error           // если задано, Send возвращает эту ошибку
+}
+
+// FakeDelivery — одно "доставленное" сообщение
+type FakeDelivery struct {
+	Token   string
+	Message Message
+}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{InvalidTokens: make(map[string]bool), Unavailable: make(map[string]int)}
+}
+
+func (s *FakeSender) MaxBatchSize() int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	return 100
+}
+
+func (s *FakeSender) Send(ctx context.Context, tokens []string, msg Message) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Batches = append(s.Batches, append([]string(nil), tokens...))
+	if s.Err != nil {
+		return Result{}, s.Err
+	}
+
+	var res Result
+	for _, token := range tokens {
+		if s.InvalidTokens[token] {
+			res.InvalidTokens = append(res.InvalidTokens, token)
+			continue
+		}
+		if s.Unavailable[token] > 0 {
+			s.Unavailable[token]--
+			res.RetryTokens = append(res.RetryTokens, token)
+			continue
+		}
+		s.Sent = append(s.Sent, FakeDelivery{Token: token, Message: msg})
+		res.Sent++
+		log.Printf("push (fake): %s -> %q %q", token, msg.Title, msg.Body)
+	}
+	if len(res.RetryTokens) > 0 {
+		return res, retryable(errors.New("fake: some tokens are temporarily unavailable"))
+	}
+	return res, nil
+}
+
+// Calls — сколько раз вызывался Send
+func (s *FakeSender) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.Batches)
+}
+
+// Deliveries возвращает копию отправленных сообщений
+func (s *FakeSender) Deliveries() []FakeDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]FakeDelivery(nil), s.Sent...)
+}
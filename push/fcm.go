@@ -0,0 +1,266 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	fcmScope       = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmSendURL     = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+
+	// FCM v1 принимает один токен на запрос, поэтому шлём параллельно
+	fcmConcurrency = 10
+)
+
+// Ключ сервисного аккаунта Firebase (JSON из консоли)
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// FCMSender отправляет push через Firebase Cloud Messaging HTTP v1 API
+type FCMSender struct {
+	ProjectID string
+	Client    *http.Client
+
+	account serviceAccount
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// NewFCMSenderFromFile читает ключ сервисного аккаунта. projectID можно не указывать —
+// тогда берётся из ключа.
+func NewFCMSenderFromFile(projectID, credentialsFile string) (*FCMSender, error) {
+	if credentialsFile == "" {
+		return nil, errors.New("FCM_CREDENTIALS_FILE is required")
+	}
+
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("fcm: invalid credentials file: %v", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = googleTokenURL
+	}
+	if projectID == "" {
+		projectID = account.ProjectID
+	}
+	if projectID == "" || account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("fcm: credentials file must contain project_id, client_email and private_key")
+	}
+
+	return &FCMSender{
+		ProjectID: projectID,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		account:   account,
+	}, nil
+}
+
+func (s *FCMSender) MaxBatchSize() int { return 500 }
+
+func (s *FCMSender) Send(ctx context.Context, tokens []string, msg Message) (Result, error) {
+	accessToken, err := s.token(ctx)
+	if err != nil {
+		return Result{}, retryable(err)
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		result   Result
+		failed   []string
+		firstErr error
+		retryErr error
+	)
+	sem := make(chan struct{}, fcmConcurrency)
+
+	for _, token := range tokens {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(token string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := s.sendOne(ctx, accessToken, token, msg)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				result.Sent++
+			case errors.Is(err, errInvalidToken):
+				result.InvalidTokens = append(result.InvalidTokens, token)
+			default:
+				var re *RetryableError
+				if errors.As(err, &re) {
+					failed = append(failed, token)
+					retryErr = err
+				} else if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}(token)
+	}
+	wg.Wait()
+
+	// Повторять имеет смысл только то, что упало временной ошибкой
+	if len(failed) > 0 {
+		result.RetryTokens = failed
+		return result, retryErr
+	}
+	return result, firstErr
+}
+
+var errInvalidToken = errors.New("fcm: invalid registration token")
+
+func (s *FCMSender) sendOne(ctx context.Context, accessToken, token string, msg Message) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]interface{}{
+			"token": token,
+			"notification": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"data": msg.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(fcmSendURL, s.ProjectID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return retryable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	respData, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return retryable(fmt.Errorf("fcm: status %d: %s", resp.StatusCode, respData))
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// токен доступа протух раньше времени — получим новый при повторе
+		s.mu.Lock()
+		s.accessToken = ""
+		s.mu.Unlock()
+		return retryable(fmt.Errorf("fcm: unauthorized: %s", respData))
+	}
+
+	// UNREGISTERED (404) и битый токен — токен надо удалить. INVALID_ARGUMENT приходит и на
+	// ошибку в самом сообщении, поэтому токен считается битым только по тексту ошибки.
+	var parsed struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(respData, &parsed)
+	for _, d := range parsed.Error.Details {
+		if d.ErrorCode == "UNREGISTERED" {
+			return errInvalidToken
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound ||
+		(parsed.Error.Status == "INVALID_ARGUMENT" && strings.Contains(parsed.Error.Message, "registration token")) {
+		return errInvalidToken
+	}
+
+	return fmt.Errorf("fcm: status %d: %s", resp.StatusCode, respData)
+}
+
+// token возвращает OAuth2 access token сервисного аккаунта (кэшируется до истечения)
+func (s *FCMSender) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Until(s.expiresAt) > time.Minute {
+		return s.accessToken, nil
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(s.account.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("fcm: invalid private key: %v", err)
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.account.ClientEmail,
+		"scope": fcmScope,
+		"aud":   s.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	respData, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fcm: token exchange failed: %s", respData)
+	}
+	if err := json.Unmarshal(respData, &tokenResp); err != nil {
+		return "", err
+	}
+
+	s.accessToken = tokenResp.AccessToken
+	s.expiresAt = now.Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	return s.accessToken, nil
+}
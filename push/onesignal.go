@@ -0,0 +1,93 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const oneSignalURL = "https://onesignal.com/api/v1/notifications"
+
+// OneSignalSender отправляет push через OneSignal REST API (UserDevice.PlayerID)
+type OneSignalSender struct {
+	AppID  string
+	APIKey string
+	URL    string
+	Client *http.Client
+}
+
+func NewOneSignalSender(appID, apiKey string) *OneSignalSender {
+	return &OneSignalSender{
+		AppID:  appID,
+		APIKey: apiKey,
+		URL:    oneSignalURL,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// OneSignal принимает до 2000 player id в одном запросе
+func (s *OneSignalSender) MaxBatchSize() int { return 2000 }
+
+func (s *OneSignalSender) Send(ctx context.Context, tokens []string, msg Message) (Result, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"app_id":             s.AppID,
+		"include_player_ids": tokens,
+		"headings":           map[string]string{"en": msg.Title},
+		"contents":           map[string]string{"en": msg.Body},
+		"data":               msg.Data,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Basic "+s.APIKey)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Result{}, retryable(err)
+	}
+	defer resp.Body.Close()
+
+	respData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Result{}, retryable(err)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return Result{}, retryable(fmt.Errorf("onesignal: status %d: %s", resp.StatusCode, respData))
+	}
+
+	// errors бывает объектом {"invalid_player_ids": [...]} или массивом строк
+	var parsed struct {
+		ID         string          `json:"id"`
+		Recipients int             `json:"recipients"`
+		Errors     json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(respData, &parsed); err != nil {
+		return Result{}, fmt.Errorf("onesignal: bad response (status %d): %s", resp.StatusCode, respData)
+	}
+
+	var result Result
+	var invalid struct {
+		InvalidPlayerIDs []string `json:"invalid_player_ids"`
+	}
+	if len(parsed.Errors) > 0 && json.Unmarshal(parsed.Errors, &invalid) == nil {
+		result.InvalidTokens = invalid.InvalidPlayerIDs
+	}
+
+	if resp.StatusCode >= 400 {
+		return result, fmt.Errorf("onesignal: status %d: %s", resp.StatusCode, respData)
+	}
+
+	result.Sent = len(tokens) - len(result.InvalidTokens)
+	return result, nil
+}
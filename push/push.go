@@ -0,0 +1,198 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go_stories_api/config"
+	"go_stories_api/models"
+	"go_stories_api/prefs"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Message — содержимое push-уведомления
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]string
+}
+
+// Result — итог отправки одной пачки
+type Result struct {
+	Sent          int
+	InvalidTokens []string // провайдер сообщил, что эти токены больше не действительны
+	RetryTokens   []string // при частичном сбое — какие токены повторить (пусто — всю пачку)
+}
+
+// Sender — провайдер push-уведомлений (OneSignal, FCM, ...)
+type Sender interface {
+	// Send отправляет сообщение на пачку токенов (не больше MaxBatchSize)
+	Send(ctx context.Context, tokens []string, msg Message) (Result, error)
+	MaxBatchSize() int
+}
+
+// RetryableError — временная ошибка провайдера (429, 5xx, сеть), отправку стоит повторить
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string { return "retryable: " + e.Err.Error() }
+func (e *RetryableError) Unwrap() error { return e.Err }
+
+func retryable(err error) error {
+	return &RetryableError{Err: err}
+}
+
+const (
+	maxAttempts = 4
+	sendTimeout = 30 * time.Second
+)
+
+// Первая задержка перед повтором (переменная, чтобы тесты не ждали секундами)
+var baseBackoff = 500 * time.Millisecond
+
+var current = struct {
+	sync.RWMutex
+	sender Sender
+}{}
+
+// SetSender задаёт провайдера. nil выключает push.
+func SetSender(s Sender) {
+	current.Lock()
+	defer current.Unlock()
+	current.sender = s
+}
+
+func getSender() Sender {
+	current.RLock()
+	defer current.RUnlock()
+	return current.sender
+}
+
+// NewSenderFromConfig создаёт провайдера по PUSH_PROVIDER
+func NewSenderFromConfig(cfg *config.Config) (Sender, error) {
+	switch cfg.PushProvider {
+	case "":
+		return nil, nil
+	case "onesignal":
+		if cfg.OneSignalAppID == "" || cfg.OneSignalAPIKey == "" {
+			return nil, errors.New("ONESIGNAL_APP_ID and ONESIGNAL_API_KEY are required")
+		}
+		return NewOneSignalSender(cfg.OneSignalAppID, cfg.OneSignalAPIKey), nil
+	case "fcm":
+		sender, err := NewFCMSenderFromFile(cfg.FCMProjectID, cfg.FCMCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		return sender, nil
+	case "fake":
+		return NewFakeSender(), nil
+	}
+	return nil, fmt.Errorf("unknown push provider %q", cfg.PushProvider)
+}
+
+// Dispatch режет токены на пачки, отправляет с повторами и собирает общий результат
+func Dispatch(ctx context.Context, s Sender, tokens []string, msg Message) (Result, error) {
+	var total Result
+	var lastErr error
+
+	batchSize := s.MaxBatchSize()
+	if batchSize <= 0 {
+		batchSize = len(tokens)
+	}
+
+	for start := 0; start < len(tokens); start += batchSize {
+		end := start + batchSize
+		if end > len(tokens) {
+			end = len(tokens)
+		}
+
+		res, err := sendWithRetry(ctx, s, tokens[start:end], msg)
+		total.Sent += res.Sent
+		total.InvalidTokens = append(total.InvalidTokens, res.InvalidTokens...)
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return total, lastErr
+}
+
+func sendWithRetry(ctx context.Context, s Sender, tokens []string, msg Message) (Result, error) {
+	var total Result
+	var err error
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			// экспоненциальная задержка с джиттером: 0.5s, 1s, 2s (+ до 50%)
+			delay := baseBackoff << (attempt - 1)
+			delay += time.Duration(rand.Int63n(int64(delay / 2)))
+			select {
+			case <-ctx.Done():
+				return total, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		var res Result
+		res, err = s.Send(ctx, tokens, msg)
+		total.Sent += res.Sent
+		total.InvalidTokens = append(total.InvalidTokens, res.InvalidTokens...)
+
+		var retryErr *RetryableError
+		if err == nil || !errors.As(err, &retryErr) {
+			return total, err
+		}
+		// Частичный сбой: повторяем только недоставленные токены
+		if len(res.RetryTokens) > 0 {
+			tokens = res.RetryTokens
+		}
+	}
+
+	return total, err
+}
+
+// SendToUsers отправляет push о событии event на все устройства пользователей.
+// Пользователи, отключившие push для события или находящиеся в тихих часах, пропускаются.
+// Токены, которые провайдер назвал недействительными, удаляются из user_devices.
+func SendToUsers(db *gorm.DB, event string, userIDs []uint, msg Message) {
+	s := getSender()
+	if s == nil || len(userIDs) == 0 {
+		return
+	}
+
+	userIDs = prefs.FilterUsers(db, userIDs, event, prefs.ChannelPush)
+	if len(userIDs) == 0 {
+		return
+	}
+
+	var tokens []string
+	db.Model(&models.UserDevice{}).
+		Where("user_id IN ? AND player_id <> ''", userIDs).
+		Distinct().
+		Pluck("player_id", &tokens)
+	if len(tokens) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	res, err := Dispatch(ctx, s, tokens, msg)
+	if err != nil {
+		log.Printf("push: send error: %v", err)
+	}
+
+	if len(res.InvalidTokens) > 0 {
+		if err := db.Where("player_id IN ?", res.InvalidTokens).Delete(&models.UserDevice{}).Error; err != nil {
+			log.Printf("push: failed to prune invalid tokens: %v", err)
+		} else {
+			log.Printf("push: pruned %d invalid device tokens", len(res.InvalidTokens))
+		}
+	}
+}
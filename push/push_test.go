@@ -0,0 +1,167 @@
+package push
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	baseBackoff = time.Millisecond
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+var msg = Message{Title: "title", Body: "body"}
+
+func TestDispatchSplitsIntoBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokens    []string
+		batchSize int
+		want      [][]string
+	}{
+		{name: "exact", tokens: []string{"a", "b", "c", "d"}, batchSize: 2, want: [][]string{{"a", "b"}, {"c", "d"}}},
+		{name: "remainder", tokens: []string{"a", "b", "c", "d", "e"}, batchSize: 2, want: [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{name: "one batch", tokens: []string{"a", "b"}, batchSize: 5, want: [][]string{{"a", "b"}}},
+		{name: "single token batches", tokens: []string{"a", "b", "c"}, batchSize: 1, want: [][]string{{"a"}, {"b"}, {"c"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFakeSender()
+			s.BatchSize = tt.batchSize
+
+			res, err := Dispatch(context.Background(), s, tt.tokens, msg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(s.Batches, tt.want) {
+				t.Fatalf("batches = %v, want %v", s.Batches, tt.want)
+			}
+			if res.Sent != len(tt.tokens) {
+				t.Fatalf("sent = %d, want %d", res.Sent, len(tt.tokens))
+			}
+		})
+	}
+}
+
+func TestDispatchCollectsInvalidTokens(t *testing.T) {
+	s := NewFakeSender()
+	s.BatchSize = 2
+	s.InvalidTokens["b"] = true
+	s.InvalidTokens["e"] = true
+
+	res, err := Dispatch(context.Background(), s, []string{"a", "b", "c", "d", "e"}, msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Sent != 3 {
+		t.Fatalf("sent = %d, want 3", res.Sent)
+	}
+	if want := []string{"b", "e"}; !reflect.DeepEqual(res.InvalidTokens, want) {
+		t.Fatalf("invalid tokens = %v, want %v", res.InvalidTokens, want)
+	}
+	if s.Calls() != 3 {
+		t.Fatalf("calls = %d, want 3 (invalid tokens are not retried)", s.Calls())
+	}
+}
+
+func TestDispatchRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		batchSize int
+		tokens    []string
+		wantCalls int
+		wantRetry bool
+	}{
+		{name: "retryable error", err: &RetryableError{Err: errors.New("503")}, tokens: []string{"a"}, wantCalls: maxAttempts, wantRetry: true},
+		{name: "retryable error per batch", err: &RetryableError{Err: errors.New("429")}, batchSize: 1, tokens: []string{"a", "b"}, wantCalls: 2 * maxAttempts, wantRetry: true},
+		{name: "permanent error", err: errors.New("400"), tokens: []string{"a"}, wantCalls: 1},
+		{name: "permanent error per batch", err: errors.New("401"), batchSize: 1, tokens: []string{"a", "b", "c"}, wantCalls: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFakeSender()
+			s.BatchSize = tt.batchSize
+			s.Err = tt.err
+
+			res, err := Dispatch(context.Background(), s, tt.tokens, msg)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			var retryErr *RetryableError
+			if errors.As(err, &retryErr) != tt.wantRetry {
+				t.Fatalf("error = %v, retryable: %v", err, tt.wantRetry)
+			}
+			if s.Calls() != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", s.Calls(), tt.wantCalls)
+			}
+			if res.Sent != 0 {
+				t.Fatalf("sent = %d, want 0", res.Sent)
+			}
+		})
+	}
+}
+
+func TestDispatchRetriesOnlyRetryTokens(t *testing.T) {
+	s := NewFakeSender()
+	s.InvalidTokens["d"] = true
+	s.Unavailable["b"] = 1
+	s.Unavailable["c"] = 2
+
+	res, err := Dispatch(context.Background(), s, []string{"a", "b", "c", "d"}, msg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := [][]string{{"a", "b", "c", "d"}, {"b", "c"}, {"c"}}
+	if !reflect.DeepEqual(s.Batches, want) {
+		t.Fatalf("batches = %v, want %v", s.Batches, want)
+	}
+	if res.Sent != 3 {
+		t.Fatalf("sent = %d, want 3", res.Sent)
+	}
+	if !reflect.DeepEqual(res.InvalidTokens, []string{"d"}) {
+		t.Fatalf("invalid tokens = %v, want [d]", res.InvalidTokens)
+	}
+}
+
+func TestDispatchGivesUpOnRetryTokens(t *testing.T) {
+	s := NewFakeSender()
+	s.Unavailable["b"] = maxAttempts
+
+	res, err := Dispatch(context.Background(), s, []string{"a", "b"}, msg)
+	var retryErr *RetryableError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("error = %v, want RetryableError", err)
+	}
+	if s.Calls() != maxAttempts {
+		t.Fatalf("calls = %d, want %d", s.Calls(), maxAttempts)
+	}
+	if res.Sent != 1 {
+		t.Fatalf("sent = %d, want 1", res.Sent)
+	}
+}
+
+func TestDispatchStopsRetryingOnCancel(t *testing.T) {
+	s := NewFakeSender()
+	s.Err = &RetryableError{Err: errors.New("503")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := Dispatch(ctx, s, []string{"a"}, msg)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if s.Calls() != 1 {
+		t.Fatalf("calls = %d, want 1", s.Calls())
+	}
+}
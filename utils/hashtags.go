@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	HashtagMinLength = 2
+	HashtagMaxLength = 50
+)
+
+// NormalizeHashtag приводит хештег к каноническому виду: без '#', в нижнем регистре.
+// Возвращает пустую строку, если тег невалиден.
+func NormalizeHashtag(raw string) string {
+	name := strings.TrimSpace(raw)
+	name = strings.TrimLeft(name, "#")
+	name = strings.ToLower(name)
+
+	length := utf8.RuneCountInString(name)
+	if length < HashtagMinLength || length > HashtagMaxLength {
+		return ""
+	}
+
+	hasLetter := false
+	for _, r := range name {
+		if !isHashtagRune(r) {
+			return ""
+		}
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		}
+	}
+	// "#2024" — это не тег, а число
+	if !hasLetter {
+		return ""
+	}
+
+	return name
+}
+
+// ExtractHashtags находит #теги в тексте (латиница, кириллица и любые другие буквы).
+// Результат нормализован и без дубликатов, в порядке появления.
+func ExtractHashtags(texts ...string) []string {
+	seen := make(map[string]bool)
+	result := make([]string, 0)
+
+	for _, text := range texts {
+		runes := []rune(text)
+		for i := 0; i < len(runes); i++ {
+			if runes[i] != '#' {
+				continue
+			}
+			// "C#" или "abc#tag" — не хештег
+			if i > 0 && isHashtagRune(runes[i-1]) {
+				continue
+			}
+
+			j := i + 1
+			for j < len(runes) && isHashtagRune(runes[j]) {
+				j++
+			}
+
+			name := NormalizeHashtag(string(runes[i+1 : j]))
+			if name != "" && !seen[name] {
+				seen[name] = true
+				result = append(result, name)
+			}
+			i = j - 1
+		}
+	}
+
+	return result
+}
+
+func isHashtagRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || unicode.Is(unicode.Mn, r)
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MentionToken — найденное в тексте @упоминание.
+// Offset и Length считаются в символах (rune), включая '@'.
+type MentionToken struct {
+	Username string
+	Offset   int
+	Length   int
+}
+
+// ExtractMentions находит @username в тексте. Адреса почты (a@b.c) не считаются.
+func ExtractMentions(text string) []MentionToken {
+	result := make([]MentionToken, 0)
+	runes := []rune(text)
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' {
+			continue
+		}
+		if i > 0 && isMentionRune(runes[i-1]) {
+			continue
+		}
+
+		j := i + 1
+		for j < len(runes) && isMentionRune(runes[j]) {
+			j++
+		}
+		// точка в конце — это конец предложения, а не часть ника
+		for j > i+1 && runes[j-1] == '.' {
+			j--
+		}
+
+		if j > i+1 {
+			result = append(result, MentionToken{
+				Username: string(runes[i+1 : j]),
+				Offset:   i,
+				Length:   j - i,
+			})
+		}
+		i = j - 1
+	}
+
+	return result
+}
+
+// MentionedUsernames возвращает уникальные ники (в нижнем регистре) из токенов
+func MentionedUsernames(tokens []MentionToken) []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0)
+	for _, t := range tokens {
+		name := strings.ToLower(t.Username)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.'
+}
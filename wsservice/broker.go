@@ -0,0 +1,127 @@
+package wsservice
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// BrokerMessage — событие для рассылки на все экземпляры API.
+// Адресовано либо пользователю (UserID), либо каналу (Channel).
+type BrokerMessage struct {
+	Origin   string   `json:"o"` // экземпляр-отправитель, сам себе он не доставляет
+	UserID   uint     `json:"u,omitempty"`
+	Channel  string   `json:"c,omitempty"`
+	Envelope Envelope `json:"e"`
+}
+
+// Broker разносит события между экземплярами API, чтобы пользователь получил их,
+// к какому бы экземпляру он ни был подключён
+type Broker interface {
+	Publish(ctx context.Context, msg BrokerMessage) error
+	// Listen передаёт в handler сообщения всех экземпляров, пока ctx не отменён
+	Listen(ctx context.Context, handler func(BrokerMessage)) error
+	Close() error
+}
+
+// NewBroker создаёт брокер по WS_BROKER: memory (по умолчанию) или postgres
+func NewBroker(ctx context.Context, kind, dsn string) (Broker, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryBroker(), nil
+	case "postgres":
+		return NewPostgresBroker(ctx, dsn)
+	}
+	return nil, fmt.Errorf("unknown ws broker %q", kind)
+}
+
+// Идентификатор этого экземпляра API
+var instanceID = newID()
+
+const brokerPublishTimeout = 5 * time.Second
+
+var broker = struct {
+	sync.RWMutex
+	b Broker
+}{}
+
+// SetBroker подключает брокер и начинает принимать события других экземпляров.
+// Без брокера события доставляются только локальным соединениям.
+func SetBroker(ctx context.Context, b Broker) {
+	broker.Lock()
+	broker.b = b
+	broker.Unlock()
+
+	go func() {
+		err := b.Listen(ctx, func(msg BrokerMessage) {
+			if msg.Origin == instanceID {
+				return
+			}
+			deliver(msg)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("ws broker stopped: %v", err)
+		}
+	}()
+}
+
+// broadcast доставляет событие локально и отправляет его остальным экземплярам
+func broadcast(msg BrokerMessage) {
+	msg.Origin = instanceID
+	deliver(msg)
+
+	broker.RLock()
+	b := broker.b
+	broker.RUnlock()
+	if b == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerPublishTimeout)
+	defer cancel()
+	if err := b.Publish(ctx, msg); err != nil {
+		log.Printf("ws broker: publish error: %v", err)
+	}
+}
+
+// MemoryBroker — брокер внутри одного процесса: для одного экземпляра и тестов
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers []func(BrokerMessage)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, msg BrokerMessage) error {
+	b.mu.RLock()
+	handlers := make([]func(BrokerMessage), len(b.handlers))
+	copy(handlers, b.handlers)
+	b.mu.RUnlock()
+
+	for _, h := range handlers {
+		h(msg)
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Listen(ctx context.Context, handler func(BrokerMessage)) error {
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handler)
+	index := len(b.handlers) - 1
+	b.mu.Unlock()
+
+	<-ctx.Done()
+
+	b.mu.Lock()
+	b.handlers[index] = func(BrokerMessage) {}
+	b.mu.Unlock()
+	return ctx.Err()
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package wsservice
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	pgNotifyChannel = "ravell_ws_events"
+	// NOTIFY принимает до 8000 байт; большие события кладём в таблицу и шлём ссылку
+	pgMaxPayload    = 7900
+	pgPayloadPrefix = "#"
+	pgPayloadTTL    = "1 minute"
+)
+
+// PostgresBroker рассылает события через LISTEN/NOTIFY той же базы, что и API
+type PostgresBroker struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresBroker(ctx context.Context, dsn string) (*PostgresBroker, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	if _, err := pool.Exec(ctx, `CREATE UNLOGGED TABLE IF NOT EXISTS ws_broker_payloads (
+		id BIGSERIAL PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`); err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &PostgresBroker{pool: pool}, nil
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, msg BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	payload := string(data)
+	if len(data) > pgMaxPayload {
+		var id int64
+		if err := b.pool.QueryRow(ctx,
+			"INSERT INTO ws_broker_payloads (payload) VALUES ($1) RETURNING id", payload).Scan(&id); err != nil {
+			return err
+		}
+		payload = pgPayloadPrefix + strconv.FormatInt(id, 10)
+
+		// Старые payload уже доставлены (или никому не нужны)
+		b.pool.Exec(ctx, "DELETE FROM ws_broker_payloads WHERE created_at < NOW() - INTERVAL '"+pgPayloadTTL+"'")
+	}
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", pgNotifyChannel, payload)
+	return err
+}
+
+// Listen держит отдельное соединение с LISTEN и переподключается при обрывах.
+// События, отправленные пока соединения нет, теряются — уведомления всё равно лежат во входящих.
+func (b *PostgresBroker) Listen(ctx context.Context, handler func(BrokerMessage)) error {
+	backoff := time.Second
+	for {
+		err := b.listen(ctx, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("ws broker: listen error: %v, reconnecting in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (b *PostgresBroker) listen(ctx context.Context, handler func(BrokerMessage)) error {
+	pooled, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// Соединение с LISTEN не должно вернуться в пул
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgNotifyChannel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		payload := notification.Payload
+		if strings.HasPrefix(payload, pgPayloadPrefix) {
+			id, _ := strconv.ParseInt(strings.TrimPrefix(payload, pgPayloadPrefix), 10, 64)
+			if err := conn.QueryRow(ctx, "SELECT payload FROM ws_broker_payloads WHERE id = $1", id).Scan(&payload); err != nil {
+				log.Printf("ws broker: payload %d not found: %v", id, err)
+				continue
+			}
+		}
+
+		var msg BrokerMessage
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			log.Printf("ws broker: bad message: %v", err)
+			continue
+		}
+		handler(msg)
+	}
+}
+
+func (b *PostgresBroker) Close() error {
+	b.pool.Close()
+	return nil
+}
@@ -0,0 +1,122 @@
+package wsservice
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion — версия протокола событий. Меняется при несовместимых изменениях.
+const ProtocolVersion = 1
+
+// События сервер -> клиент
+const (
+	EventWelcome        = "welcome"
+	EventAck            = "ack"
+	EventError          = "error"
+	EventPong           = "pong"
+	EventNotification   = "notification"
+	EventUnreadCount    = "notification.unread_count"
+	EventStoryReply     = "story.reply"
+	EventStoryCounters  = "story.counters"
+	EventCommentCreated = "comment.created"
+	EventHashtagStory   = "hashtag.story"
+	EventStoryReaders   = "story.readers"
+
+	EventMessageNew          = "message.new"
+	EventMessageDelivered    = "message.delivered"
+	EventMessageRead         = "message.read"
+	EventConversationUpdated = "conversation.updated"
+)
+
+// Команды клиент -> сервер
+const (
+	CommandSubscribe   = "subscribe"
+	CommandUnsubscribe = "unsubscribe"
+	CommandPing        = "ping"
+
+	CommandMessageSend      = "message.send"
+	CommandMessageDelivered = "message.delivered"
+	CommandMessageRead      = "message.read"
+)
+
+// Типы каналов, на которые можно подписаться
+const (
+	ChannelStory   = "story"
+	ChannelHashtag = "hashtag"
+)
+
+// Envelope — конверт любого сообщения сервера
+type Envelope struct {
+	V         int         `json:"v"`
+	Type      string      `json:"type"`
+	ID        string      `json:"id"`
+	Timestamp time.Time   `json:"ts"`
+	Channel   string      `json:"channel,omitempty"`
+	ReplyTo   string      `json:"reply_to,omitempty"` // id команды клиента, на которую это ответ
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// ClientMessage — команда от клиента
+type ClientMessage struct {
+	V       int             `json:"v"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Channel string          `json:"channel"`
+	Data    json.RawMessage `json:"data"` // параметры команды
+}
+
+func NewEnvelope(eventType string, data interface{}) Envelope {
+	return Envelope{
+		V:         ProtocolVersion,
+		Type:      eventType,
+		ID:        newID(),
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+}
+
+// Ack подтверждает команду клиента
+func Ack(replyTo string, data interface{}) Envelope {
+	env := NewEnvelope(EventAck, data)
+	env.ReplyTo = replyTo
+	return env
+}
+
+// Error сообщает клиенту об ошибке в команде
+func Error(replyTo, message string) Envelope {
+	env := NewEnvelope(EventError, map[string]string{"message": message})
+	env.ReplyTo = replyTo
+	return env
+}
+
+func StoryChannel(storyID uint) string {
+	return fmt.Sprintf("%s:%d", ChannelStory, storyID)
+}
+
+func HashtagChannel(hashtagID uint) string {
+	return fmt.Sprintf("%s:%d", ChannelHashtag, hashtagID)
+}
+
+// ParseChannel разбирает "story:12" на тип и id
+func ParseChannel(channel string) (string, uint, error) {
+	kind, rawID, ok := strings.Cut(channel, ":")
+	if !ok || (kind != ChannelStory && kind != ChannelHashtag) {
+		return "", 0, fmt.Errorf("unknown channel %q", channel)
+	}
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil || id == 0 {
+		return "", 0, fmt.Errorf("invalid channel id in %q", channel)
+	}
+	return kind, uint(id), nil
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
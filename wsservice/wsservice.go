@@ -1,43 +1,370 @@
 package wsservice
 
 import (
+	"context"
+	"errors"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// map[userID] -> список соединений
-var connections = struct {
+const (
+	// Сколько каналов может слушать одно соединение
+	maxSubscriptions = 100
+	// Сколько одновременных соединений у одного пользователя; лишние вытесняют самые старые
+	maxConnectionsPerUser = 5
+
+	// Очередь исходящих сообщений соединения. Переполнилась — клиент не успевает читать, отключаем.
+	sendQueueSize = 64
+	writeWait     = 10 * time.Second
+	pongWait      = 60 * time.Second
+	pingPeriod    = pongWait * 9 / 10
+	closeWait     = time.Second
+	// Максимальный размер команды от клиента
+	maxMessageSize = 4096
+)
+
+var (
+	ErrTooManySubscriptions = errors.New("too many subscriptions")
+	ErrShuttingDown         = errors.New("server is shutting down")
+)
+
+// Client — одно WebSocket-соединение пользователя.
+// Писать в соединение может только его writer-горутина, остальные кладут сообщения в очередь.
+type Client struct {
+	UserID uint
+
+	conn      *websocket.Conn
+	send      chan Envelope
+	done      chan struct{} // закрыт — соединение завершается
+	closeOnce sync.Once
+	closeMsg  []byte
+
+	subs map[string]bool // защищено hub
+}
+
+// Send ставит конверт в очередь. Если очередь полна, клиент считается зависшим и отключается.
+func (c *Client) Send(env Envelope) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- env:
+		return true
+	case <-c.done:
+		return false
+	default:
+		log.Printf("ws: send queue overflow for user %d, dropping connection", c.UserID)
+		c.Close(websocket.CloseTryAgainLater, "send queue overflow")
+		return false
+	}
+}
+
+// Close завершает соединение с кодом и причиной (отправит writer)
+func (c *Client) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeMsg = websocket.FormatCloseMessage(code, reason)
+		close(c.done)
+	})
+}
+
+// Done закрывается, когда соединение завершается
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// writePump — единственный писатель соединения: очередь, пинги и закрытие
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		hub.wg.Done()
+	}()
+
+	for {
+		select {
+		case env := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteJSON(env); err != nil {
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.Close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage, c.closeMsg, time.Now().Add(closeWait))
+			return
+		}
+	}
+}
+
+// ReadLoop читает сообщения клиента, пока соединение живо. Клиент, не ответивший
+// на ping за pongWait, отключается по таймауту чтения.
+func (c *Client) ReadLoop(handle func(data []byte)) {
+	defer c.Close(websocket.CloseNormalClosure, "")
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		handle(data)
+	}
+}
+
+// map[userID] -> список соединений, map[канал] -> подписанные соединения
+var hub = struct {
 	sync.RWMutex
-	clients map[uint][]*websocket.Conn
-}{clients: make(map[uint][]*websocket.Conn)}
+	clients  map[uint][]*Client
+	channels map[string]map[*Client]bool
+	closing  bool
+	wg       sync.WaitGroup // writer-горутины
+}{
+	clients:  make(map[uint][]*Client),
+	channels: make(map[string]map[*Client]bool),
+}
+
+// Добавить соединение и запустить его writer
+func AddConnection(userID uint, conn *websocket.Conn) (*Client, error) {
+	client := &Client{
+		UserID: userID,
+		conn:   conn,
+		send:   make(chan Envelope, sendQueueSize),
+		done:   make(chan struct{}),
+		subs:   make(map[string]bool),
+	}
+
+	hub.Lock()
+	if hub.closing {
+		hub.Unlock()
+		return nil, ErrShuttingDown
+	}
+
+	var evicted []*Client
+	conns := hub.clients[userID]
+	for len(conns) >= maxConnectionsPerUser {
+		evicted = append(evicted, conns[0])
+		conns = conns[1:]
+	}
+	hub.clients[userID] = append(conns, client)
+	hub.wg.Add(1)
+	hub.Unlock()
+
+	for _, old := range evicted {
+		old.Close(websocket.ClosePolicyViolation, "too many connections")
+	}
 
-// Добавить соединение
-func AddConnection(userID uint, conn *websocket.Conn) {
-	connections.Lock()
-	defer connections.Unlock()
-	connections.clients[userID] = append(connections.clients[userID], conn)
+	go client.writePump()
+	return client, nil
 }
 
-// Удалить соединение
-func RemoveConnection(userID uint, conn *websocket.Conn) {
-	connections.Lock()
-	defer connections.Unlock()
-	conns := connections.clients[userID]
+// Удалить соединение вместе с его подписками
+func RemoveConnection(client *Client) {
+	client.Close(websocket.CloseNormalClosure, "")
+
+	hub.Lock()
+	defer hub.Unlock()
+
+	conns := hub.clients[client.UserID]
 	for i, c := range conns {
-		if c == conn {
-			connections.clients[userID] = append(conns[:i], conns[i+1:]...)
+		if c == client {
+			hub.clients[client.UserID] = append(conns[:i:i], conns[i+1:]...)
 			break
 		}
 	}
+	if len(hub.clients[client.UserID]) == 0 {
+		delete(hub.clients, client.UserID)
+	}
+
+	for channel := range client.subs {
+		removeSubscriber(channel, client)
+	}
+	client.subs = nil
+}
+
+// Shutdown закрывает все соединения (клиенты получают 1001 Going Away)
+// и ждёт завершения writer-горутин или отмены ctx
+func Shutdown(ctx context.Context) error {
+	hub.Lock()
+	hub.closing = true
+	var all []*Client
+	for _, conns := range hub.clients {
+		all = append(all, conns...)
+	}
+	hub.Unlock()
+
+	for _, c := range all {
+		c.Close(websocket.CloseGoingAway, "server shutdown")
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		hub.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe подписывает соединение на канал (story:<id>, hashtag:<id>)
+func Subscribe(client *Client, channel string) error {
+	hub.Lock()
+	defer hub.Unlock()
+
+	if client.subs == nil {
+		return ErrShuttingDown
+	}
+	if client.subs[channel] {
+		return nil
+	}
+	if len(client.subs) >= maxSubscriptions {
+		return ErrTooManySubscriptions
+	}
+
+	client.subs[channel] = true
+	if hub.channels[channel] == nil {
+		hub.channels[channel] = make(map[*Client]bool)
+	}
+	hub.channels[channel][client] = true
+	return nil
+}
+
+// Unsubscribe отписывает соединение от канала
+func Unsubscribe(client *Client, channel string) {
+	hub.Lock()
+	defer hub.Unlock()
+
+	if !client.subs[channel] {
+		return
+	}
+	delete(client.subs, channel)
+	removeSubscriber(channel, client)
+}
+
+func removeSubscriber(channel string, client *Client) {
+	delete(hub.channels[channel], client)
+	if len(hub.channels[channel]) == 0 {
+		delete(hub.channels, channel)
+	}
+}
+
+// SendToUser отправляет событие во все соединения пользователя на всех экземплярах
+func SendToUser(userID uint, eventType string, data interface{}) {
+	broadcast(BrokerMessage{UserID: userID, Envelope: NewEnvelope(eventType, data)})
+}
+
+// Publish отправляет событие всем подписчикам канала на всех экземплярах
+func Publish(channel, eventType string, data interface{}) {
+	env := NewEnvelope(eventType, data)
+	env.Channel = channel
+	broadcast(BrokerMessage{Channel: channel, Envelope: env})
+}
+
+// deliver отправляет событие локальным соединениям
+func deliver(msg BrokerMessage) {
+	var targets []*Client
+
+	hub.RLock()
+	if msg.Channel != "" {
+		targets = make([]*Client, 0, len(hub.channels[msg.Channel]))
+		for c := range hub.channels[msg.Channel] {
+			targets = append(targets, c)
+		}
+	} else {
+		targets = append(targets, hub.clients[msg.UserID]...)
+	}
+	hub.RUnlock()
+
+	for _, c := range targets {
+		c.Send(msg.Envelope)
+	}
 }
 
 // Отправить уведомление конкретному пользователю
 func SendNotification(userID uint, message interface{}) {
-	connections.RLock()
-	defer connections.RUnlock()
-	conns := connections.clients[userID]
-	for _, c := range conns {
-		c.WriteJSON(message)
+	SendToUser(userID, EventNotification, message)
+}
+
+// InstanceID — идентификатор этого экземпляра API
+func InstanceID() string {
+	return instanceID
+}
+
+// LocalUsers — пользователи, подключённые к этому экземпляру, и число их соединений
+func LocalUsers() map[uint]int {
+	hub.RLock()
+	defer hub.RUnlock()
+
+	users := make(map[uint]int, len(hub.clients))
+	for userID, conns := range hub.clients {
+		users[userID] = len(conns)
+	}
+	return users
+}
+
+// LocalChannelUsers — для каждого канала пользователи, подписанные на него на этом экземпляре
+func LocalChannelUsers() map[string][]uint {
+	hub.RLock()
+	defer hub.RUnlock()
+
+	result := make(map[string][]uint, len(hub.channels))
+	for channel, subscribers := range hub.channels {
+		seen := make(map[uint]bool)
+		for c := range subscribers {
+			if !seen[c.UserID] {
+				seen[c.UserID] = true
+				result[channel] = append(result[channel], c.UserID)
+			}
+		}
+	}
+	return result
+}
+
+// IsSubscribed — подписано ли хотя бы одно соединение пользователя на канал на этом экземпляре
+func IsSubscribed(userID uint, channel string) bool {
+	hub.RLock()
+	defer hub.RUnlock()
+
+	for c := range hub.channels[channel] {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels — каналы, на которые подписано соединение
+func (c *Client) Channels() []string {
+	hub.RLock()
+	defer hub.RUnlock()
+
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
 	}
+	return channels
 }